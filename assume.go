@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var forcedEncoding = flag.String("encoding", "", "skip encoding detection and treat every file as this `encoding`. Extensions mapped with --assume still use their own encoding")
+var assume = flag.String("assume", "", "comma separated `pattern=encoding` pairs (e.g. .go=utf-8,*.csv=windows-1252). Matching files skip encoding detection and are treated as the given encoding. A pattern starting with a dot is an extension, anything else a glob matched against the file name. The first match wins")
+
+var assumeText = flag.Bool("assume-text", false, "treat files encoding detection isn't confident about as UTF-8 text anyway, unless they look binary to the --detect=heuristic rule")
+var assumeTextExtensionsFlag = flag.String("assume-text-extensions", "", "comma separated extensions that limit --assume-text to files with one of them")
+
+// assumeTextExtensions is the parsed --assume-text-extensions, nil for every
+// extension.
+var assumeTextExtensions []string
+
+// assumeRule assumes files matching pattern use encoding.
+type assumeRule struct {
+	pattern  string
+	encoding string
+}
+
+// assumeRules are the parsed --assume pairs.
+var assumeRules []assumeRule
+
+func parseAssume(value string) ([]assumeRule, error) {
+	var rules []assumeRule
+	if value == "" {
+		return rules, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		pattern, encoding, ok := strings.Cut(pair, "=")
+		pattern, encoding = strings.TrimSpace(pattern), strings.TrimSpace(encoding)
+		if !ok || encoding == "" || pattern == "" || pattern == "." {
+			return nil, fmt.Errorf("invalid --assume entry %q, expected pattern=encoding", pair)
+		}
+		if !supportedEncodings.Contains(strings.ToUpper(encoding)) {
+			return nil, fmt.Errorf("invalid --assume entry %q: unsupported encoding %s", pair, encoding)
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid --assume entry %q: %w", pair, err)
+		}
+		rules = append(rules, assumeRule{pattern: pattern, encoding: encoding})
+	}
+	return rules, nil
+}
+
+// assumedEncoding returns the encoding of the first --assume pair matching
+// path.
+func assumedEncoding(path string) (string, bool) {
+	for _, rule := range assumeRules {
+		if matchesNamePattern(rule.pattern, path) {
+			return rule.encoding, true
+		}
+	}
+	return "", false
+}
+
+// assumedDetection returns a detection for path based on --assume, if it
+// matches one of the pairs. The file is still sniffed for NUL bytes so an
+// obviously binary file with a text extension isn't rewritten, unless the
+// encoding is one where NUL bytes are expected.
+func assumedDetection(path string) (d detection, ok bool, err error) {
+	encoding, ok := assumedEncoding(path)
+	if !ok {
+		return d, false, nil
+	}
+	if decodableEncodings.Contains(strings.ToUpper(encoding)) {
+		log.Debug("assuming encoding from extension", "path", path, "encoding", encoding)
+		return detection{isText: true, encoding: encoding, confidence: 1}, true, nil
+	}
+	binary, err := hasNUL(path, *probeSize)
+	if err != nil {
+		return d, true, err
+	}
+	if binary {
+		d.reason = reasonBinary
+		return d, true, nil
+	}
+	log.Debug("assuming encoding from extension", "path", path, "encoding", encoding)
+	return detection{isText: true, encoding: encoding, confidence: 1}, true, nil
+}
+
+// assumedText returns d for path as UTF-8 text, if --assume-text applies to
+// it: detection wasn't confident, the probe passes looksLikeText and the
+// extension is allowed.
+func assumedText(path string, d detection) (detection, bool) {
+	if !*assumeText || (d.reason != reasonBinary && d.reason != reasonInconclusive) {
+		return d, false
+	}
+	if len(d.probe) == 0 || !looksLikeText(d.probe) {
+		return d, false
+	}
+	if assumeTextExtensions != nil && !slices.Contains(assumeTextExtensions, strings.ToLower(filepath.Ext(path))) {
+		return d, false
+	}
+	log.Debug("assuming text", "path", path, "reason", d.reason, "confidence", d.confidence)
+	d.isText, d.encoding, d.reason = true, "UTF-8", ""
+	return d, true
+}
+
+// forcedDetection returns the detection for the content of file under
+// --encoding. Like an assumed encoding, the first probe-size bytes are
+// sniffed for NUL bytes unless the encoding is expected to have them.
+func forcedDetection(file io.Reader) (d detection, err error) {
+	buf := make([]byte, *probeSize)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return d, err
+	}
+	d.bytesRead = int64(n)
+	d.probe = buf[:n]
+	if n == 0 || (!decodableEncodings.Contains(strings.ToUpper(*forcedEncoding)) && bytes.IndexByte(d.probe, 0) >= 0) {
+		d.reason = reasonBinary
+		return d, nil
+	}
+	d.isText, d.encoding, d.confidence = true, *forcedEncoding, 1
+	return d, nil
+}
+
+// hasNUL reports whether the first n bytes of path contain a NUL byte.
+func hasNUL(path string, n int) (bool, error) {
+	head, err := readHead(path, n)
+	if err != nil {
+		return false, err
+	}
+	return bytes.IndexByte(head, 0) >= 0, nil
+}
+
+// readHead returns the first n bytes of the file at path, or all of them if
+// it's shorter.
+func readHead(path string, n int) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	buf := make([]byte, n)
+	read, err := io.ReadFull(file, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return buf[:read], nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestAssumeSkipsDetection(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		content  string
+		encoding string
+		reason   string
+		detected bool
+	}{
+		{"extension", "notes.txt", "caf\xe9\r\n", "windows-1252", "", false},
+		{"glob", "data.csv", "a;b\r\n", "windows-1252", "", false},
+		{"first match wins", "main.go", "package main\n", "utf-8", "", false},
+		{"NUL bytes are binary", "blob.txt", "a\x00b\r\n", "", reasonBinary, false},
+		{"NUL bytes in UTF-16", "wide.md", "a\x00\r\x00\n\x00", "utf-16le", "", false},
+		{"unmapped extension", "readme.rst", "plain text\r\n", "", "", true},
+	}
+	setFlags(t, map[string]string{
+		"assume":   ".txt=windows-1252,*.csv=windows-1252,.go=utf-8,*.go=windows-1252,.md=utf-16le",
+		"no-cache": "true",
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{tt.file: tt.content})
+			d, err := detectForRewrite(filepath.Join(dir, tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if detected := d.bytesRead > 0; detected != tt.detected {
+				t.Errorf("detection ran: %v, want %v", detected, tt.detected)
+			}
+			if tt.detected {
+				return
+			}
+			if d.encoding != tt.encoding || d.reason != tt.reason {
+				t.Errorf("got encoding %q and reason %q, want %q and %q", d.encoding, d.reason, tt.encoding, tt.reason)
+			}
+			if d.isText != (tt.reason == "") {
+				t.Errorf("isText is %v", d.isText)
+			}
+			if res := handleFile(filepath.Join(dir, tt.file)); res.Err != nil || res.Encoding != tt.encoding {
+				t.Errorf("handleFile got encoding %q and error %v, want %q", res.Encoding, res.Err, tt.encoding)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"strings"
+)
+
+var trimBOMOnly = flag.Bool("trim-bom-only", false, "only strip leading UTF-8 BOMs, leaving line endings and everything else untouched")
+var stripBOM = flag.Bool("strip-bom", false, "also remove a leading UTF-8 BOM while normalizing line endings. Same as --bom=strip")
+var bomMode = flag.String("bom", bomKeep, "what to do with the UTF-8 BOM of UTF-8 and ASCII files while normalizing line endings: keep, add (write one if it's missing) or strip")
+
+// --bom policies
+const (
+	bomKeep  = "keep"
+	bomAdd   = "add"
+	bomStrip = "strip"
+)
+
+// bomsStripped counts the files --trim-bom-only removed a BOM from.
+var bomsStripped int
+
+// trimLeadingBOM removes the UTF-8 BOM from the start of path, if it has one.
+// Files without a BOM aren't rewritten.
+func trimLeadingBOM(path, encoding string) (sums contentHashes, err error) {
+	if !utf8Encodings.Contains(strings.ToUpper(encoding)) {
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	prefix := make([]byte, len(utf8BOM))
+	n, err := io.ReadFull(file, prefix)
+	file.Close()
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return sums, err
+	}
+	if !bytes.Equal(prefix[:n], utf8BOM) {
+		return sums, nil
+	}
+	log.Info("stripping BOM", "path", path)
+	bomsStripped++
+	rewrite := func(input io.Reader, output io.Writer) error {
+		if _, err := io.CopyN(io.Discard, input, int64(len(utf8BOM))); err != nil {
+			return err
+		}
+		_, err := io.Copy(output, input)
+		return err
+	}
+	if *dryRun {
+		return dryRewrite(path, rewrite)
+	}
+	return safeFileRewrite(path, rewrite)
+}
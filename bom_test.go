@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTrimBOMOnly(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		want     string
+		stripped int
+	}{
+		{"BOM", "\xef\xbb\xbfa\r\nb  \r\n", "a\r\nb  \r\n", 1},
+		{"BOM only", "\xef\xbb\xbf", "", 1},
+		{"no BOM", "a\r\nb\n", "a\r\nb\n", 0},
+		{"BOM later in the file", "a\r\n\xef\xbb\xbfb\r\n", "a\r\n\xef\xbb\xbfb\r\n", 0},
+		{"UTF-16 BOM", "\xff\xfea\x00\r\x00\n\x00", "\xff\xfea\x00\r\x00\n\x00", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t, map[string]string{"trim-bom-only": "true", "no-cache": "true"})
+			t.Cleanup(func() { bomsStripped = 0 })
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"file.txt": tt.content})
+			path := filepath.Join(dir, "file.txt")
+			before, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := handleFile(path)
+			if res.Err != nil {
+				t.Fatal(res.Err)
+			}
+			if got := readFile(t, path); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if bomsStripped != tt.stripped {
+				t.Errorf("stripped %d BOMs, want %d", bomsStripped, tt.stripped)
+			}
+			if res.Changed != (tt.stripped > 0) {
+				t.Errorf("Changed is %v", res.Changed)
+			}
+			after, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.stripped == 0 && !os.SameFile(before, after) {
+				t.Error("a file without a BOM was rewritten")
+			}
+		})
+	}
+}
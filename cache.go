@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var noCache = flag.Bool("no-cache", false, "don't read or write the detection cache, which remembers the detected encoding of unchanged files between runs")
+var clearCache = flag.Bool("clear-cache", false, "delete the detection cache before running")
+
+// cacheEntry is a cached detection for a file with the given size and
+// modification time.
+type cacheEntry struct {
+	Size    int64
+	ModTime int64
+	// Settings are the detection options the result was found with, see
+	// detectionSettings.
+	Settings   string
+	IsText     bool
+	Encoding   string
+	Confidence float64
+	Language   string
+	Reason     string
+}
+
+// detectionCache maps absolute paths to their last detection. It's loaded on
+// first use and written back by saveDetectionCache if anything was added.
+var detectionCache map[string]cacheEntry
+var detectionCacheDirty bool
+
+// racyInterval is how recently a file may have been modified for its
+// detection to be left out of the cache. A file changed again within the
+// filesystem's timestamp granularity could keep its size and modification
+// time, and the stale entry would never be noticed.
+const racyInterval = 2 * time.Second
+
+// cachePath returns where the detection cache is kept.
+func cachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "fix-lines", "detections.json"), nil
+}
+
+// useCache reports whether detections are cached in this run. Forced
+// encodings skip detection anyway, and --scan-only is there to measure it.
+func useCache() bool {
+	return !*noCache && !*scanOnly && *forcedEncoding == ""
+}
+
+// detectionSettings describes the options that affect the outcome of
+// detectReader, so an entry isn't reused under different ones.
+func detectionSettings() string {
+	return fmt.Sprintf("%d/%d/%v/%v/%s/%v", *probeSize, *maxChunks, *minConfidence, *fallbackConfidence, *detectStrategy, *sniffContentType)
+}
+
+func loadDetectionCache() {
+	detectionCache = map[string]cacheEntry{}
+	path, err := cachePath()
+	if err != nil {
+		log.Debug("no detection cache", "error", err)
+		return
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Warn("couldn't read the detection cache", "path", path, "error", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(content, &detectionCache); err != nil {
+		log.Warn("ignoring corrupt detection cache", "path", path, "error", err)
+		detectionCache = map[string]cacheEntry{}
+	}
+}
+
+// cachedDetection returns the cached detection of path, if there is one for
+// its current size and modification time.
+func cachedDetection(path string, info os.FileInfo) (d detection, ok bool) {
+	if detectionCache == nil {
+		loadDetectionCache()
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return d, false
+	}
+	e, ok := detectionCache[abs]
+	if !ok || e.Size != info.Size() || e.ModTime != info.ModTime().UnixNano() || e.Settings != detectionSettings() {
+		return d, false
+	}
+	log.Debug("using cached detection", "path", path, "encoding", e.Encoding)
+	return detection{
+		isText:     e.IsText,
+		encoding:   e.Encoding,
+		confidence: e.Confidence,
+		language:   e.Language,
+		reason:     e.Reason,
+	}, true
+}
+
+// cacheDetection remembers d as the detection of path.
+func cacheDetection(path string, info os.FileInfo, d detection) {
+	if time.Since(info.ModTime()) < racyInterval {
+		return
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return
+	}
+	if detectionCache == nil {
+		loadDetectionCache()
+	}
+	detectionCache[abs] = cacheEntry{
+		Size:       info.Size(),
+		ModTime:    info.ModTime().UnixNano(),
+		Settings:   detectionSettings(),
+		IsText:     d.isText,
+		Encoding:   d.encoding,
+		Confidence: d.confidence,
+		Language:   d.language,
+		Reason:     d.reason,
+	}
+	detectionCacheDirty = true
+}
+
+// saveDetectionCache writes the cache back if it changed. Entries for files
+// that no longer exist are dropped on the way.
+func saveDetectionCache() error {
+	if !detectionCacheDirty {
+		return nil
+	}
+	path, err := cachePath()
+	if err != nil {
+		return err
+	}
+	for file := range detectionCache {
+		if _, err := os.Lstat(file); errors.Is(err, fs.ErrNotExist) {
+			delete(detectionCache, file)
+		}
+	}
+	content, err := json.Marshal(detectionCache)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "detections-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// removeDetectionCache deletes the cache file for --clear-cache.
+func removeDetectionCache() error {
+	path, err := cachePath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
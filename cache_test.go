@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAssumeTextWithCache(t *testing.T) {
+	setFlags(t, map[string]string{"assume-text": "true", "fallback-confidence": "1"})
+	t.Cleanup(func() { detectionCache, detectionCacheDirty = nil, false })
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	writeFiles(t, filepath.Dir(path), map[string]string{"notes.txt": "caf\xe9 cr\xe8me br\xfbl\xe9e\r\n"})
+	// files modified within racyInterval aren't cached
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	for run := 1; run <= 2; run++ {
+		detectionCache = nil
+		d, err := detectForRewrite(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !d.isText || d.encoding != "UTF-8" {
+			t.Errorf("run %d: got isText %v, encoding %q, want UTF-8 text", run, d.isText, d.encoding)
+		}
+		if err := saveDetectionCache(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	abs, _ := filepath.Abs(path)
+	if _, ok := detectionCache[abs]; !ok {
+		t.Error("the detection wasn't cached")
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strings"
+)
+
+var sniffContentType = flag.Bool("sniff-content-type", true, "treat files whose first bytes are the signature of a known binary format, like PDF, PNG or zip, as binary even when they look like text to encoding detection")
+
+// binaryContentTypes are the prefixes of the http.DetectContentType results
+// that are always binary. Text formats like HTML and XML, and its catch-all
+// application/octet-stream, are left to encoding detection.
+var binaryContentTypes = []string{
+	"image/", "audio/", "video/", "font/",
+	"application/pdf", "application/zip",
+	"application/x-gzip", "application/x-rar-compressed", "application/wasm",
+	"application/vnd.ms-fontobject", "application/ogg",
+}
+
+// binaryContentType returns the content type of probe if it starts with the
+// signature of a binary format, or "" otherwise or without
+// --sniff-content-type.
+func binaryContentType(probe []byte) string {
+	if !*sniffContentType {
+		return ""
+	}
+	contentType := http.DetectContentType(probe)
+	for _, prefix := range binaryContentTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return contentType
+		}
+	}
+	return ""
+}
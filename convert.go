@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/transform"
+)
+
+var convertToUTF8 = flag.Bool("convert-to-utf8", false, "also re-encode text files that aren't UTF-8 or ASCII to UTF-8. Use --bom to choose whether they get a BOM")
+
+// filesConverted counts the files --convert-to-utf8 re-encoded.
+var filesConverted int
+
+// legacyDecoders are the codecs used to convert the byteSafeEncodings that
+// aren't UTF-8 compatible. Where the detector's name is ambiguous, the
+// superset is used: TIS-620 files are decoded as Windows-874 and GB2312 as
+// GB18030. EUC-TW and Johab have no decoder and are never converted.
+var legacyDecoders = map[string]encoding.Encoding{
+	"ISO-8859-1":   charmap.ISO8859_1,
+	"ISO-8859-2":   charmap.ISO8859_2,
+	"ISO-8859-5":   charmap.ISO8859_5,
+	"ISO-8859-6":   charmap.ISO8859_6,
+	"ISO-8859-7":   charmap.ISO8859_7,
+	"ISO-8859-8":   charmap.ISO8859_8,
+	"ISO-8859-9":   charmap.ISO8859_9,
+	"ISO-8859-13":  charmap.ISO8859_13,
+	"WINDOWS-1250": charmap.Windows1250,
+	"WINDOWS-1251": charmap.Windows1251,
+	"WINDOWS-1252": charmap.Windows1252,
+	"WINDOWS-1253": charmap.Windows1253,
+	"WINDOWS-1254": charmap.Windows1254,
+	"WINDOWS-1255": charmap.Windows1255,
+	"WINDOWS-1256": charmap.Windows1256,
+	"WINDOWS-1257": charmap.Windows1257,
+	"KOI8-R":       charmap.KOI8R,
+	"MACROMAN":     charmap.Macintosh,
+	"MACCYRILLIC":  charmap.MacintoshCyrillic,
+	"IBM855":       charmap.CodePage855,
+	"IBM866":       charmap.CodePage866,
+	"TIS-620":      charmap.Windows874,
+	"SHIFT_JIS":    japanese.ShiftJIS,
+	"CP932":        japanese.ShiftJIS,
+	"EUC-JP":       japanese.EUCJP,
+	"ISO-2022-JP":  japanese.ISO2022JP,
+	"EUC-KR":       korean.EUCKR,
+	"CP949":        korean.EUCKR,
+	"GB2312":       simplifiedchinese.GB18030,
+	"BIG5":         traditionalchinese.Big5,
+}
+
+// canConvert reports whether --convert-to-utf8 can re-encode content in
+// encoding, given in upper case, to UTF-8.
+func canConvert(encoding string) bool {
+	_, ok := legacyDecoders[encoding]
+	return ok || decodableEncodings.Contains(encoding)
+}
+
+// errNotConvertible is returned when content doesn't survive a round trip
+// through the encoding it was detected as, so converting it could lose data.
+var errNotConvertible = errors.New("content isn't valid in its detected encoding, not converting it to UTF-8")
+
+// lossCheckReader fails with errNotConvertible unless the decoded content
+// read through it encodes back to exactly the source bytes. A decoder writes
+// U+FFFD for bytes it can't decode, which either can't be encoded at all or,
+// in GB18030, encodes to something else. Looking for U+FFFD itself isn't
+// enough, since GB18030 has a code for it.
+type lossCheckReader struct {
+	r io.Reader
+	// source holds the source bytes that were read but not yet matched by
+	// the re-encoded content.
+	source bytes.Buffer
+	// encoder re-encodes the decoded content into compare.
+	encoder *transform.Writer
+}
+
+// newLossCheckReader returns a lossCheckReader decoding input from enc.
+func newLossCheckReader(input io.Reader, enc encoding.Encoding) *lossCheckReader {
+	l := &lossCheckReader{}
+	l.r = transform.NewReader(io.TeeReader(input, &l.source), enc.NewDecoder())
+	l.encoder = transform.NewWriter(sourceMatcher{l}, enc.NewEncoder())
+	return l
+}
+
+func (l *lossCheckReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	if _, werr := l.encoder.Write(p[:n]); werr != nil {
+		return n, errNotConvertible
+	}
+	if err == io.EOF {
+		if cerr := l.encoder.Close(); cerr != nil || l.source.Len() > 0 {
+			return n, errNotConvertible
+		}
+	}
+	return n, err
+}
+
+// sourceMatcher consumes the source bytes of a lossCheckReader that match
+// what's written to it.
+type sourceMatcher struct{ l *lossCheckReader }
+
+func (m sourceMatcher) Write(p []byte) (int, error) {
+	if !bytes.HasPrefix(m.l.source.Bytes(), p) {
+		return 0, errNotConvertible
+	}
+	m.l.source.Next(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/wlynxg/chardet"
+)
+
+var detectDetails = flag.String("detect-details", "", "with --scan-only, also print the detector's full result for every file, including its language guess and the other candidate encodings, as `format` text or json (one object per line)")
+
+// --detect-details formats
+const (
+	detailsText = "text"
+	detailsJSON = "json"
+)
+
+// detectionDetails is what --detect-details reports for a file.
+type detectionDetails struct {
+	Path       string           `json:"path"`
+	IsText     bool             `json:"isText"`
+	Encoding   string           `json:"encoding"`
+	Confidence float64          `json:"confidence"`
+	Language   string           `json:"language"`
+	BytesRead  int64            `json:"bytesRead"`
+	Reason     string           `json:"reason,omitempty"`
+	Candidates []chardet.Result `json:"candidates"`
+}
+
+func newDetectionDetails(path string, d detection) detectionDetails {
+	details := detectionDetails{
+		Path:       path,
+		IsText:     d.isText,
+		Encoding:   d.encoding,
+		Confidence: d.confidence,
+		Language:   d.language,
+		BytesRead:  d.bytesRead,
+		Reason:     d.reason,
+	}
+	if len(d.probe) > 0 {
+		details.Candidates = chardet.DetectAll(d.probe)
+	}
+	return details
+}
+
+func writeDetectionDetails(w io.Writer, path string, d detection) error {
+	details := newDetectionDetails(path, d)
+	if *detectDetails == detailsJSON {
+		return json.NewEncoder(w).Encode(details)
+	}
+	encoding := details.Encoding
+	if !details.IsText {
+		encoding = details.Reason
+	}
+	if _, err := fmt.Fprintf(w, "%s: %s confidence=%.2f language=%q bytes=%d\n", path, encoding, details.Confidence, details.Language, details.BytesRead); err != nil {
+		return err
+	}
+	for _, c := range details.Candidates {
+		if _, err := fmt.Fprintf(w, "  candidate %s confidence=%.2f language=%q\n", c.Encoding, c.Confidence, c.Language); err != nil {
+			return err
+		}
+	}
+	return nil
+}
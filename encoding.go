@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/wyattis/z/zset/zstringset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/encoding/unicode/utf32"
+	"golang.org/x/text/transform"
+)
+
+// byteSafeEncodings are ASCII supersets in which the bytes 0x0D and 0x0A only
+// ever mean CR and LF and never appear inside a multibyte sequence. Line
+// endings in these encodings can be normalized directly on the raw bytes
+// without decoding anything, and the rest of the content is written back
+// untouched in its original encoding.
+var byteSafeEncodings = zstringset.New(
+	"ASCII", "UTF-8", "UTF-8-SIG",
+	"ISO-8859-1", "ISO-8859-2", "ISO-8859-5", "ISO-8859-6", "ISO-8859-7",
+	"ISO-8859-8", "ISO-8859-9", "ISO-8859-13",
+	"WINDOWS-1250", "WINDOWS-1251", "WINDOWS-1252", "WINDOWS-1253",
+	"WINDOWS-1254", "WINDOWS-1255", "WINDOWS-1256", "WINDOWS-1257",
+	"KOI8-R", "MACROMAN", "MACCYRILLIC", "IBM855", "IBM866", "TIS-620",
+	// the trail bytes of these double-byte encodings are all 0x30 or above
+	"SHIFT_JIS", "CP932", "EUC-JP", "EUC-KR", "EUC-TW", "CP949", "JOHAB",
+	"GB2312", "BIG5",
+	// 7-bit, with escape sequences switching character sets. Both bytes of a
+	// JIS X 0208 character are in 0x21-0x7E, so CR and LF are always literal.
+	"ISO-2022-JP",
+)
+
+// decodableEncodings are encodings where a CR or LF byte can be part of a
+// wider code unit, so line endings can only be found after decoding.
+// fix-lines decodes them before normalizing: the content is normalized as
+// UTF-8 and encoded back to the original encoding.
+var decodableEncodings = zstringset.New(
+	"UTF-16", "UTF-16LE", "UTF-16BE",
+	"UTF-32", "UTF-32LE", "UTF-32BE",
+)
+
+// utf8Encodings are the detector's names for UTF-8, with and without a BOM.
+var utf8Encodings = zstringset.New("UTF-8", "UTF-8-SIG")
+
+var supportedEncodings = zstringset.NewUnion(byteSafeEncodings, decodableEncodings)
+
+// boms maps byte order marks to the detector's name for the encoding they
+// start. The UTF-32 LE BOM starts with the UTF-16 LE one, so it's checked
+// first.
+var boms = []struct {
+	bom      []byte
+	encoding string
+}{
+	{[]byte{0xEF, 0xBB, 0xBF}, "UTF-8-SIG"},
+	{[]byte{0xFF, 0xFE, 0x00, 0x00}, "UTF-32"},
+	{[]byte{0x00, 0x00, 0xFE, 0xFF}, "UTF-32"},
+	{[]byte{0xFF, 0xFE}, "UTF-16"},
+	{[]byte{0xFE, 0xFF}, "UTF-16"},
+}
+
+// bomEncoding returns the encoding the BOM at the start of head stands for,
+// or "" if it doesn't start with one.
+func bomEncoding(head []byte) string {
+	for _, b := range boms {
+		if bytes.HasPrefix(head, b.bom) {
+			return b.encoding
+		}
+	}
+	return ""
+}
+
+// codeUnits returns the code unit width in bytes and the byte order of one of
+// the decodableEncodings. The detector says "UTF-16" or "UTF-32" when the
+// content starts with a BOM, so head, the first bytes of the content, is used
+// to tell the byte order.
+func codeUnits(name string, head []byte) (width int, bigEndian bool) {
+	switch strings.ToUpper(name) {
+	case "UTF-16":
+		return 2, bytes.HasPrefix(head, []byte{0xFE, 0xFF})
+	case "UTF-16LE":
+		return 2, false
+	case "UTF-16BE":
+		return 2, true
+	case "UTF-32":
+		return 4, bytes.HasPrefix(head, []byte{0x00, 0x00, 0xFE, 0xFF})
+	case "UTF-32LE":
+		return 4, false
+	case "UTF-32BE":
+		return 4, true
+	}
+	return 0, false
+}
+
+// textEncoding returns the codec for one of the decodableEncodings, see
+// codeUnits. The codec for "UTF-16" and "UTF-32" keeps the BOM: the decoder
+// strips it and the encoder writes it back.
+func textEncoding(name string, head []byte) encoding.Encoding {
+	width, bigEndian := codeUnits(name, head)
+	upper := strings.ToUpper(name)
+	withBOM := upper == "UTF-16" || upper == "UTF-32"
+	switch width {
+	case 2:
+		order, bom := unicode.LittleEndian, unicode.IgnoreBOM
+		if bigEndian {
+			order = unicode.BigEndian
+		}
+		if withBOM {
+			bom = unicode.UseBOM
+		}
+		return unicode.UTF16(order, bom)
+	case 4:
+		order, bom := utf32.LittleEndian, utf32.IgnoreBOM
+		if bigEndian {
+			order = utf32.BigEndian
+		}
+		if withBOM {
+			bom = utf32.UseBOM
+		}
+		return utf32.UTF32(order, bom)
+	}
+	return nil
+}
+
+// decodingReader returns input decoded to UTF-8, along with the codec used,
+// if name is one of the decodableEncodings. Other input is returned as is
+// with a nil codec.
+func decodingReader(name string, input io.Reader) (io.Reader, encoding.Encoding) {
+	if !decodableEncodings.Contains(strings.ToUpper(name)) {
+		return input, nil
+	}
+	buf := bufio.NewReader(input)
+	head, _ := buf.Peek(4)
+	width, bigEndian := codeUnits(name, head)
+	valid := &codeUnitReader{r: buf, width: width, bigEndian: bigEndian}
+	enc := textEncoding(name, head)
+	return transform.NewReader(valid, enc.NewDecoder()), enc
+}
+
+// errInvalidCodeUnits is returned for UTF-16 or UTF-32 content the decoder
+// would have to replace parts of, so encoding it back wouldn't give the
+// original bytes.
+var errInvalidCodeUnits = errors.New("content isn't valid in its detected encoding, rewriting it would change more than line endings")
+
+// codeUnitReader passes UTF-16 or UTF-32 content through unchanged, failing
+// with errInvalidCodeUnits at the first unpaired surrogate, code point out of
+// range or incomplete code unit. The decoders turn those into U+FFFD, which
+// would silently change the file when it's encoded again.
+type codeUnitReader struct {
+	r         io.Reader
+	width     int
+	bigEndian bool
+	// partial is the start of a code unit split across reads.
+	partial []byte
+	// highSurrogate is set when the last UTF-16 code unit read opened a
+	// surrogate pair.
+	highSurrogate bool
+}
+
+func (c *codeUnitReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	units := append(c.partial, p[:n]...)
+	for len(units) >= c.width {
+		if !c.valid(units[:c.width]) {
+			return n, errInvalidCodeUnits
+		}
+		units = units[c.width:]
+	}
+	c.partial = append(c.partial[:0], units...)
+	if err == io.EOF && (len(c.partial) > 0 || c.highSurrogate) {
+		return n, errInvalidCodeUnits
+	}
+	return n, err
+}
+
+// valid checks the code unit u, width bytes long.
+func (c *codeUnitReader) valid(u []byte) bool {
+	var v uint32
+	for i := range u {
+		b := u[i]
+		if !c.bigEndian {
+			b = u[len(u)-1-i]
+		}
+		v = v<<8 | uint32(b)
+	}
+	isHigh := v >= 0xD800 && v <= 0xDBFF
+	isLow := v >= 0xDC00 && v <= 0xDFFF
+	if c.width == 4 {
+		return v <= 0x10FFFF && !isHigh && !isLow
+	}
+	if c.highSurrogate {
+		c.highSurrogate = isHigh
+		return isLow
+	}
+	c.highSurrogate = isHigh
+	return !isLow
+}
+
+// decoded returns a reader over content decoded from the named encoding.
+func decoded(name string, content []byte) io.Reader {
+	r, _ := decodingReader(name, bytes.NewReader(content))
+	return r
+}
+
+// encodedRewrite returns a rewrite callback that runs rw over content in the
+// named encoding, decoding and re-encoding it if needed. When rw converts
+// the content to UTF-8 it's decoded and written out as UTF-8.
+func (rw *lineRewriter) encodedRewrite(name string) func(input io.Reader, output io.Writer) error {
+	return func(input io.Reader, output io.Writer) error {
+		decoded, enc := decodingReader(name, input)
+		if rw.convertFrom != "" {
+			if enc == nil {
+				decoded = newLossCheckReader(input, legacyDecoders[strings.ToUpper(name)])
+			}
+			return rw.rewrite(decoded, output)
+		}
+		if enc == nil {
+			return rw.rewrite(decoded, output)
+		}
+		encoded := transform.NewWriter(output, enc.NewEncoder())
+		if err := rw.rewrite(decoded, encoded); err != nil {
+			return err
+		}
+		return encoded.Close()
+	}
+}
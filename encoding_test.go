@@ -0,0 +1,71 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestInvalidCodeUnitsSkipFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		content  string
+	}{
+		{"lone high surrogate", "utf-16le", "a\x00\r\x00\n\x00\x00\xd8b\x00\r\x00\n\x00"},
+		{"lone low surrogate", "utf-16be", "\x00a\x00\r\x00\n\xdc\x00\x00b"},
+		{"code point out of range", "utf-32le", "a\x00\x00\x00\r\x00\x00\x00\n\x00\x00\x00\x00\x00\x11\x00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			setFlags(t, map[string]string{"assume": "bad.txt=" + tt.encoding, "skip-report": filepath.Join(dir, "report.txt"), "no-cache": "true"})
+			t.Cleanup(func() { skippedPaths = map[string][]string{} })
+			writeFiles(t, dir, map[string]string{"bad.txt": tt.content, "good.txt": "a\r\nb\r\n"})
+			if err := processPaths([]string{dir}); err != nil {
+				t.Fatalf("the run failed: %v", err)
+			}
+			if got := readFile(t, filepath.Join(dir, "bad.txt")); got != tt.content {
+				t.Errorf("the invalid file was changed to % x", got)
+			}
+			if got := readFile(t, filepath.Join(dir, "good.txt")); got != "a\nb\n" {
+				t.Errorf("the valid file wasn't processed, got %q", got)
+			}
+			if !slices.Contains(skippedPaths[reasonInvalidContent], filepath.Join(dir, "bad.txt")) {
+				t.Errorf("bad.txt isn't in the skip report, got %v", skippedPaths)
+			}
+		})
+	}
+}
+
+func TestTruncatedCodeUnitSkipsFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"UTF-16LE with BOM and half a unit", "\xff\xfea\x00\r\x00\n\x00b"},
+		{"UTF-16BE with BOM and half a unit", "\xfe\xff\x00a\x00\r\x00\n\x00"},
+		{"UTF-16LE ending in a high surrogate", "\xff\xfea\x00\r\x00\n\x00\x3d\xd8"},
+		{"UTF-32LE with BOM and three bytes of a unit", "\xff\xfe\x00\x00a\x00\x00\x00\r\x00\x00\x00\n\x00\x00\x00b\x00\x00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			setFlags(t, map[string]string{"skip-report": filepath.Join(dir, "report.txt"), "no-cache": "true"})
+			t.Cleanup(func() { skippedPaths = map[string][]string{} })
+			writeFiles(t, dir, map[string]string{"a.txt": tt.content, "b.txt": "a\r\n"})
+			if err := processPaths([]string{dir}); err != nil {
+				t.Fatalf("the run failed: %v", err)
+			}
+			if got := readFile(t, filepath.Join(dir, "a.txt")); got != tt.content {
+				t.Errorf("the truncated file was changed to % x", got)
+			}
+			if got := readFile(t, filepath.Join(dir, "b.txt")); got != "a\n" {
+				t.Errorf("the other file wasn't processed, got %q", got)
+			}
+			if !slices.Contains(skippedPaths[reasonInvalidContent], filepath.Join(dir, "a.txt")) {
+				t.Errorf("a.txt isn't in the skip report, got %v", skippedPaths)
+			}
+		})
+	}
+}
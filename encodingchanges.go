@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+)
+
+var reportEncodingChanges = flag.String("report-encoding-changes", "", "at the end of the run, print how many changed files were re-encoded and how many kept their encoding, listing the re-encoded ones, as `format` text or json")
+
+// encodingChange is a file whose encoding was changed, e.g. by
+// --convert-to-utf8.
+type encodingChange struct {
+	Path string `json:"path"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// encodingChangeSummary is what --report-encoding-changes prints.
+type encodingChangeSummary struct {
+	// EncodingChanged lists the changed files that were re-encoded.
+	EncodingChanged []encodingChange `json:"encodingChanged"`
+	// EncodingKept counts the changed files that kept their encoding, whose
+	// lines were normalized in place.
+	EncodingKept int `json:"encodingKept"`
+}
+
+var encodingChanges = encodingChangeSummary{EncodingChanged: []encodingChange{}}
+
+// recordEncodingChange counts res for --report-encoding-changes, if the file
+// was changed.
+func recordEncodingChange(res FileResult) {
+	switch {
+	case !res.Changed:
+	case res.EncodingChanged:
+		encodingChanges.EncodingChanged = append(encodingChanges.EncodingChanged, encodingChange{Path: res.Path, From: res.Stats.ConvertedFrom, To: "UTF-8"})
+	default:
+		encodingChanges.EncodingKept++
+	}
+}
+
+func writeEncodingChanges(w io.Writer) error {
+	if *reportEncodingChanges == detailsJSON {
+		return json.NewEncoder(w).Encode(encodingChanges)
+	}
+	if _, err := fmt.Fprintf(w, "%d files changed encoding, %d files kept their encoding\n", len(encodingChanges.EncodingChanged), encodingChanges.EncodingKept); err != nil {
+		return err
+	}
+	for _, c := range encodingChanges.EncodingChanged {
+		if _, err := fmt.Fprintf(w, "  %s: %s → %s\n", c.Path, c.From, c.To); err != nil {
+			return err
+		}
+	}
+	return nil
+}
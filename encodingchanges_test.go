@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReportEncodingChanges(t *testing.T) {
+	for _, format := range []string{detailsText, detailsJSON} {
+		t.Run(format, func(t *testing.T) {
+			setFlags(t, map[string]string{
+				"assume":                  "*.csv=windows-1252,.txt=utf-8",
+				"convert-to-utf8":         "true",
+				"report-encoding-changes": format,
+				"no-cache":                "true",
+			})
+			t.Cleanup(func() { encodingChanges = encodingChangeSummary{EncodingChanged: []encodingChange{}} })
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"prices.csv": "caf\xe9,\x803\r\n",
+				"a.txt":      "a\r\n",
+				"b.txt":      "b\r\n",
+				"same.txt":   "unchanged\n",
+			})
+			if err := processPaths([]string{dir}); err != nil {
+				t.Fatal(err)
+			}
+			csv := filepath.Join(dir, "prices.csv")
+			if got := readFile(t, csv); got != "café,€3\n" {
+				t.Fatalf("got %q", got)
+			}
+			var out bytes.Buffer
+			if err := writeEncodingChanges(&out); err != nil {
+				t.Fatal(err)
+			}
+			if format == detailsText {
+				want := "1 files changed encoding, 2 files kept their encoding\n  " + csv + ": WINDOWS-1252 → UTF-8\n"
+				if !strings.EqualFold(out.String(), want) {
+					t.Errorf("got %q, want %q", out.String(), want)
+				}
+				return
+			}
+			var got encodingChangeSummary
+			if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.EncodingKept != 2 || len(got.EncodingChanged) != 1 || got.EncodingChanged[0].Path != csv || !strings.EqualFold(got.EncodingChanged[0].From, "windows-1252") || got.EncodingChanged[0].To != "UTF-8" {
+				t.Errorf("got %+v", got)
+			}
+		})
+	}
+}
+
+func TestFileResultEncodingChanged(t *testing.T) {
+	setFlags(t, map[string]string{"assume": ".txt=windows-1252", "no-cache": "true"})
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"latin.txt": "caf\xe9\r\n"})
+	path := filepath.Join(dir, "latin.txt")
+	if res := handleFile(path); res.Err != nil || !res.Changed || res.EncodingChanged {
+		t.Fatalf("without --convert-to-utf8 got %+v", res)
+	}
+	writeFiles(t, dir, map[string]string{"latin.txt": "caf\xe9\r\n"})
+	setFlags(t, map[string]string{"convert-to-utf8": "true"})
+	if res := handleFile(path); res.Err != nil || !res.Changed || !res.EncodingChanged {
+		t.Fatalf("with --convert-to-utf8 got %+v", res)
+	}
+}
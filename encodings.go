@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+var encodingsReport = flag.Bool("encodings", false, "don't modify anything, print the encoding and confidence detected for every file and whether a rewrite would process it, followed by how many files use each encoding")
+
+// Statuses in the --encodings output, in addition to the skip reasons.
+const (
+	statusOK          = "ok"
+	statusUnsupported = "unsupported"
+)
+
+// runEncodings prints the detection result of every file under paths the way
+// a rewrite would see it, with --assume and the other detection options
+// applied, followed by how many files have each encoding.
+func runEncodings(paths []string) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tENCODING\tCONFIDENCE\tSTATUS")
+	encodings := map[string]int{}
+	for _, path := range paths {
+		if err := handlePath(path, func(path string) error {
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			var d detection
+			status := statusOK
+			if info.Size() == 0 {
+				status = reasonEmpty
+			} else if status, err = prefilter(path, info); err != nil {
+				return err
+			} else if status == "" {
+				if d, err = detectForRewrite(path); err != nil {
+					return err
+				}
+				switch {
+				case !d.isText:
+					status = d.reason
+				case !supportedEncodings.Contains(strings.ToUpper(d.encoding)):
+					status = statusUnsupported
+				default:
+					status = statusOK
+				}
+			}
+			name := d.encoding
+			if !d.isText {
+				name = "-"
+			}
+			encodings[name]++
+			fmt.Fprintf(tw, "%s\t%s\t%.2f\t%s\n", path, name, d.confidence, status)
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	return printEncodingSummary(os.Stdout, encodings)
+}
+
+// printEncodingSummary prints the file count per encoding, most common first.
+// Files that aren't text are counted under "-".
+func printEncodingSummary(w io.Writer, encodings map[string]int) error {
+	names := make([]string, 0, len(encodings))
+	for name := range encodings {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if encodings[names[i]] != encodings[names[j]] {
+			return encodings[names[i]] > encodings[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENCODING\tFILES")
+	for _, name := range names {
+		fmt.Fprintf(tw, "%s\t%d\n", name, encodings[name])
+	}
+	return tw.Flush()
+}
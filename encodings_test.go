@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+func TestCJKRoundTrip(t *testing.T) {
+	tests := []struct {
+		encoding string
+		codec    encoding.Encoding
+		// text has characters whose trail bytes are ASCII, like the 0x5C of
+		// Shift_JIS 表
+		text string
+	}{
+		{"shift_jis", japanese.ShiftJIS, "表示する\nソース\n能力\n"},
+		{"euc-jp", japanese.EUCJP, "日本語のテキスト\n漢字とかな\n"},
+		{"iso-2022-jp", japanese.ISO2022JP, "日本語のテキスト\nASCII の行\n"},
+		{"gb2312", simplifiedchinese.GBK, "简体中文\n源代码\n"},
+		{"big5", traditionalchinese.Big5, "繁體中文\n許功蓋\n"},
+		{"euc-kr", korean.EUCKR, "한국어 텍스트\n소스 코드\n"},
+	}
+	for _, tt := range tests {
+		for _, eol := range []string{"lf", "crlf"} {
+			t.Run(tt.encoding+"/"+eol, func(t *testing.T) {
+				setFlags(t, map[string]string{"assume": ".txt=" + tt.encoding, "eol": eol, "no-cache": "true"})
+				encode := func(s string) string {
+					encoded, err := tt.codec.NewEncoder().String(s)
+					if err != nil {
+						t.Fatal(err)
+					}
+					return encoded
+				}
+				want := tt.text
+				if eol == "crlf" {
+					want = strings.ReplaceAll(want, "\n", "\r\n")
+				}
+				// every line ending is the wrong one either way
+				input := strings.ReplaceAll(tt.text, "\n", "\r")
+				dir := t.TempDir()
+				writeFiles(t, dir, map[string]string{"file.txt": encode(input)})
+				path := filepath.Join(dir, "file.txt")
+				res := handleFile(path)
+				if res.Err != nil || !res.Changed {
+					t.Fatalf("got error %v, changed %v", res.Err, res.Changed)
+				}
+				got, err := tt.codec.NewDecoder().String(readFile(t, path))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+				if raw := readFile(t, path); raw != encode(want) {
+					t.Errorf("got % x, want % x", raw, encode(want))
+				}
+			})
+		}
+	}
+}
+
+func TestWindows1252RoundTrip(t *testing.T) {
+	// every high-half byte, including the ones windows-1252 leaves
+	// undefined, right before and after a line ending of each kind
+	var lines []string
+	for b := 0x80; b <= 0xFF; b++ {
+		lines = append(lines, string([]byte{byte(b)}), string([]byte{'x', byte(b), byte(b)}))
+	}
+	endings := []string{"\r\n", "\r", "\n"}
+	var input strings.Builder
+	for i, line := range lines {
+		input.WriteString(line + endings[i%len(endings)])
+	}
+	for _, tt := range []struct{ eol, terminator string }{{"lf", "\n"}, {"crlf", "\r\n"}} {
+		t.Run(tt.eol, func(t *testing.T) {
+			setFlags(t, map[string]string{"assume": ".txt=windows-1252", "eol": tt.eol, "no-cache": "true"})
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"file.txt": input.String()})
+			path := filepath.Join(dir, "file.txt")
+			if res := handleFile(path); res.Err != nil || !res.Changed {
+				t.Fatalf("got error %v, changed %v", res.Err, res.Changed)
+			}
+			want := strings.Join(lines, tt.terminator) + tt.terminator
+			if got := readFile(t, path); got != want {
+				t.Errorf("got % x, want % x", got, want)
+			}
+			if res := handleFile(path); res.Err != nil || res.Changed {
+				t.Errorf("the second run got error %v, changed %v", res.Err, res.Changed)
+			}
+		})
+	}
+}
+
+func TestCJKConvertToUTF8(t *testing.T) {
+	tests := []struct {
+		encoding string
+		codec    encoding.Encoding
+		text     string
+	}{
+		{"shift_jis", japanese.ShiftJIS, "表示する\r\nソース\r\n"},
+		{"euc-jp", japanese.EUCJP, "日本語のテキスト\r\n"},
+		{"gb2312", simplifiedchinese.GBK, "简体中文\r\n"},
+		{"big5", traditionalchinese.Big5, "繁體中文\r\n許功蓋\r\n"},
+		{"euc-kr", korean.EUCKR, "한국어 텍스트\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.encoding, func(t *testing.T) {
+			setFlags(t, map[string]string{"assume": ".txt=" + tt.encoding, "convert-to-utf8": "true", "no-cache": "true"})
+			encoded, err := tt.codec.NewEncoder().String(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"file.txt": encoded})
+			path := filepath.Join(dir, "file.txt")
+			if res := handleFile(path); res.Err != nil {
+				t.Fatal(res.Err)
+			}
+			if got, want := readFile(t, path), strings.ReplaceAll(tt.text, "\r\n", "\n"); got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestConvertChecksRoundTrip(t *testing.T) {
+	longText := strings.Repeat("简体中文，源代码。\r\n", 2000)
+	long, err := simplifiedchinese.GB18030.NewEncoder().String(longText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		encoding string
+		content  string
+		// want is the converted content, or "" if the file can't be
+		// converted and must be left alone
+		want string
+	}{
+		// GB18030 has a code for U+FFFD, so decoding one isn't a loss
+		{"GB18030 replacement character", "gb2312", "\xd6\xd0\x84\x31\xa4\x37\r\n", "中\ufffd\n"},
+		{"GB2312 across reads", "gb2312", long, strings.ReplaceAll(longText, "\r\n", "\n")},
+		{"invalid GB2312", "gb2312", "\xd6\xd0\xff\r\n", ""},
+		{"invalid Shift_JIS", "shift_jis", "\x95\x5c\x81\r\n", ""},
+		{"undefined windows-1252", "windows-1252", "caf\xe9\x81\r\n", ""},
+		{"ISO-2022-JP", "iso-2022-jp", "\x1b$BF|K\\8l\x1b(B\r\n", "日本語\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t, map[string]string{"assume": ".txt=" + tt.encoding, "convert-to-utf8": "true", "no-cache": "true"})
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"file.txt": tt.content})
+			path := filepath.Join(dir, "file.txt")
+			res := handleFile(path)
+			if tt.want == "" {
+				if !errors.Is(res.Err, errNotConvertible) {
+					t.Errorf("got error %v, want %v", res.Err, errNotConvertible)
+				}
+				if got := readFile(t, path); got != tt.content {
+					t.Errorf("the file was modified: % x", got)
+				}
+				return
+			}
+			if res.Err != nil {
+				t.Fatal(res.Err)
+			}
+			if got := readFile(t, path); got != tt.want {
+				t.Errorf("got %q, want %q", got[:min(len(got), 80)], tt.want[:min(len(tt.want), 80)])
+			}
+		})
+	}
+}
+
+func TestCJKDetected(t *testing.T) {
+	tests := []struct {
+		codec encoding.Encoding
+		text  string
+	}{
+		{japanese.ShiftJIS, "日本語のテキストです。これはソースコードのコメントとして書かれた文章で、表示や能力といった文字も含まれています。\r\n"},
+		{japanese.EUCJP, "日本語のテキストです。これはソースコードのコメントとして書かれた文章で、表示や能力といった文字も含まれています。\r\n"},
+		{simplifiedchinese.GBK, "这是一段简体中文文本，用于测试编码检测是否正确，以及换行符是否能够被正常地转换。\r\n"},
+		{traditionalchinese.Big5, "這是一段繁體中文文本，用於測試編碼檢測是否正確，以及換行符號是否能夠被正常地轉換。\r\n"},
+		{korean.EUCKR, "이것은 한국어 텍스트입니다. 인코딩 감지가 올바른지, 그리고 줄 바꿈 문자가 정상적으로 변환되는지 확인합니다.\r\n"},
+	}
+	setFlags(t, map[string]string{"no-cache": "true"})
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.codec), func(t *testing.T) {
+			encoded, err := tt.codec.NewEncoder().String(strings.Repeat(tt.text, 5))
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"file.txt": encoded})
+			path := filepath.Join(dir, "file.txt")
+			res := handleFile(path)
+			if res.Err != nil || res.SkippedReason != "" {
+				t.Fatalf("got error %v, skipped %q", res.Err, res.SkippedReason)
+			}
+			want, _ := tt.codec.NewEncoder().String(strings.Repeat(strings.ReplaceAll(tt.text, "\r\n", "\n"), 5))
+			if got := readFile(t, path); got != want {
+				t.Errorf("detected as %s, got % x", res.Encoding, got)
+			}
+		})
+	}
+}
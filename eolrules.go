@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+var eolRulesFlag = flag.String("eol-rules", "", "comma separated `pattern=eol` pairs overriding --eol for matching files, e.g. .bat=crlf,.cmd=crlf,Makefile=lf. A pattern starting with a dot is an extension, anything else a glob matched against the file name. The first match wins")
+
+// eolRule overrides the target line ending for files matching pattern.
+type eolRule struct {
+	pattern string
+	eol     string
+}
+
+// eolRules are the parsed --eol-rules.
+var eolRules []eolRule
+
+func parseEOLRules(value string) ([]eolRule, error) {
+	var rules []eolRule
+	if value == "" {
+		return rules, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		pattern, eol, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || pattern == "" || pattern == "." {
+			return nil, fmt.Errorf("invalid --eol-rules entry %q, expected pattern=eol", pair)
+		}
+		if _, ok := eolTerminators[eol]; !ok {
+			return nil, fmt.Errorf("invalid --eol-rules entry %q: unknown line ending %s", pair, eol)
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid --eol-rules entry %q: %w", pair, err)
+		}
+		rules = append(rules, eolRule{pattern: pattern, eol: eol})
+	}
+	return rules, nil
+}
+
+// eolFor returns the terminator files at path are normalized to: that of the
+// first matching --eol-rules entry, or --eol.
+func eolFor(path string) string {
+	for _, rule := range eolRules {
+		if matchesNamePattern(rule.pattern, path) {
+			return eolTerminators[rule.eol]
+		}
+	}
+	return eolTerminators[*eol]
+}
+
+// matchesNamePattern reports whether the file name of path matches pattern,
+// which is an extension if it starts with a dot and has no glob characters,
+// and a glob otherwise.
+func matchesNamePattern(pattern, path string) bool {
+	name := filepath.Base(path)
+	if strings.HasPrefix(pattern, ".") && !strings.ContainsAny(pattern, "*?[") {
+		return strings.EqualFold(filepath.Ext(name), pattern)
+	}
+	match, _ := filepath.Match(pattern, name)
+	return match
+}
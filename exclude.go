@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wyattis/z/zflag"
+	"github.com/wyattis/z/zset/zstringset"
+)
+
+var excludeDirs = zflag.StringSlice()
+var excludePatterns = zflag.StringSlice()
+var skipVCSDirs = flag.Bool("skip-vcs-dirs", true, "never descend into .git, .hg, .svn or .bzr directories while walking, even with --hidden, since rewriting files in them can corrupt the repository")
+var noDefaultExcludes = flag.Bool("no-default-excludes", false, "also descend into vendor, node_modules, target, dist and the other well-known dependency and build output directories")
+
+// vcsDirs are the metadata directories of version control systems.
+var vcsDirs = zstringset.New(".git", ".hg", ".svn", ".bzr")
+
+// defaultExcludeDirs are directory names that almost always hold third-party
+// code or build output, which nobody wants normalized.
+var defaultExcludeDirs = zstringset.New(
+	"vendor", "third_party", "node_modules", "bower_components", "Pods",
+	"target", "dist", "__pycache__", "venv", ".venv", ".tox",
+)
+
+func init() {
+	flag.Var(excludeDirs, "exclude-dir", "don't descend into directories with this `name` (e.g. node_modules). Names containing a slash are matched against the path relative to the directory being walked instead. Can be repeated or comma separated")
+	flag.Var(excludePatterns, "exclude", "skip files and directories matching this .gitignore style `pattern`, e.g. '*.min.js' or 'vendor/**'. Patterns containing a slash are matched against the path relative to the directory being walked, or to the working directory for paths given as arguments. Can be repeated or comma separated")
+}
+
+// excludeRules are the parsed --exclude patterns.
+var excludeRules []ignoreRule
+
+func parseExcludes(patterns []string) ([]ignoreRule, error) {
+	var rules []ignoreRule
+	for _, pattern := range patterns {
+		rule, ok := parseIgnoreLine(pattern)
+		if !ok || rule.negate {
+			return nil, fmt.Errorf("invalid --exclude pattern %q", pattern)
+		}
+		rules = append(rules, rule)
+		// "dir/**" only matches what's inside dir, so match dir itself as well
+		// to keep the walk out of it
+		if dir, ok := strings.CutSuffix(strings.TrimSpace(pattern), "/**"); ok && dir != "" {
+			if rule, ok := parseIgnoreLine(dir + "/"); ok {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// isExcludedPath reports whether path, found while walking root, matches
+// --exclude. Paths outside of root never do.
+func isExcludedPath(root, path string, isDir bool) bool {
+	if len(excludeRules) == 0 {
+		return false
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	for _, rule := range excludeRules {
+		if (!rule.dirOnly || isDir) && rule.re.MatchString(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// isExcludedArg reports whether path, given as an argument, matches
+// --exclude relative to the working directory. Only name patterns apply to
+// arguments outside of it.
+func isExcludedArg(path string, isDir bool) bool {
+	if len(excludeRules) == 0 {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return false
+	}
+	if rel, err := filepath.Rel(cwd, abs); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+		return isExcludedPath(cwd, abs, isDir)
+	}
+	return isExcludedPath(filepath.Dir(abs), abs, isDir)
+}
+
+// isExcludedDir reports whether the directory at path, found while walking
+// root, matches --exclude-dir or is a VCS metadata or default excluded
+// directory.
+func isExcludedDir(root, path string) bool {
+	if path == root {
+		return false
+	}
+	name := filepath.Base(path)
+	if *skipVCSDirs && vcsDirs.Contains(name) {
+		return true
+	}
+	if !*noDefaultExcludes && defaultExcludeDirs.Contains(name) {
+		return true
+	}
+	if excludeDirs.Len() == 0 {
+		return false
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = path
+	}
+	rel = filepath.ToSlash(rel)
+	for _, pattern := range excludeDirs.Val() {
+		pattern = strings.Trim(filepath.ToSlash(pattern), "/")
+		if pattern == "" {
+			continue
+		}
+		if strings.Contains(pattern, "/") {
+			if rel == pattern {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
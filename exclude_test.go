@@ -0,0 +1,149 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExcludeDir(t *testing.T) {
+	tree := map[string]string{
+		"main.go":              "",
+		"cache/a.txt":          "",
+		"src/app.go":           "",
+		"src/gen/types.go":     "",
+		"src/cache/b.txt":      "",
+		"docs/gen/index.md":    "",
+		"lib/cache/deep/c.txt": "",
+		// hidden files are recorded as skipped when their directory is walked
+		"cache/.env":   "",
+		"src/gen/.env": "",
+	}
+	tests := []struct {
+		excludeDir string
+		want       []string
+	}{
+		{"", []string{"cache/a.txt", "docs/gen/index.md", "lib/cache/deep/c.txt", "main.go", "src/app.go", "src/cache/b.txt", "src/gen/types.go"}},
+		{"cache", []string{"docs/gen/index.md", "main.go", "src/app.go", "src/gen/types.go"}},
+		{"src/gen", []string{"cache/a.txt", "docs/gen/index.md", "lib/cache/deep/c.txt", "main.go", "src/app.go", "src/cache/b.txt"}},
+		{"/src/gen/", []string{"cache/a.txt", "docs/gen/index.md", "lib/cache/deep/c.txt", "main.go", "src/app.go", "src/cache/b.txt"}},
+		{"gen,cache", []string{"main.go", "src/app.go"}},
+		{"src", []string{"cache/a.txt", "docs/gen/index.md", "lib/cache/deep/c.txt", "main.go"}},
+		{"app.go", []string{"cache/a.txt", "docs/gen/index.md", "lib/cache/deep/c.txt", "main.go", "src/app.go", "src/cache/b.txt", "src/gen/types.go"}},
+	}
+	for _, tt := range tests {
+		t.Run("exclude-dir="+tt.excludeDir, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tree)
+			flags := map[string]string{"skip-report": filepath.Join(t.TempDir(), "skipped.txt")}
+			if tt.excludeDir != "" {
+				flags["exclude-dir"] = tt.excludeDir
+			}
+			setFlags(t, flags)
+			t.Cleanup(func() { skippedPaths = map[string][]string{} })
+			var got []string
+			err := handleDir(dir, func(path string) error {
+				rel, err := filepath.Rel(dir, path)
+				got = append(got, filepath.ToSlash(rel))
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			// nothing below an excluded directory is even looked at, not
+			// even to skip it as hidden
+			for _, paths := range skippedPaths {
+				for _, path := range paths {
+					rel, _ := filepath.Rel(dir, path)
+					for _, name := range strings.Split(tt.excludeDir, ",") {
+						if name = strings.Trim(name, "/"); name != "" && strings.Contains(filepath.ToSlash(rel), name+"/") {
+							t.Errorf("%s is below an excluded directory, but was skipped separately", rel)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestVCSDirsPruned(t *testing.T) {
+	tree := map[string]string{
+		"main.go":                       "package main\r\n",
+		".git/config":                   "[core]\r\n",
+		".git/HEAD":                     "ref: refs/heads/main\r\n",
+		".git/objects/ab/cdef0123":      "x\x9c\r\n",
+		".git/objects/pack/pack-1.pack": "PACK\r\n",
+		".git/objects/pack/pack-1.idx":  "\xfftOc\r\n",
+		"sub/.hg/store/data.i":          "x\r\n",
+		"sub/.svn/entries":              "12\r\n",
+		"sub/.bzr/branch-format":        "Bazaar\r\n",
+		"sub/.github/workflows/ci.yml":  "on: push\r\n",
+	}
+	tests := []struct {
+		name  string
+		flags map[string]string
+		want  []string
+	}{
+		{"hidden", map[string]string{"hidden": "true"}, []string{"main.go", "sub/.github/workflows/ci.yml"}},
+		{"skip-vcs-dirs=false", map[string]string{"hidden": "true", "skip-vcs-dirs": "false"}, []string{
+			".git/HEAD", ".git/config", ".git/objects/ab/cdef0123", ".git/objects/pack/pack-1.idx", ".git/objects/pack/pack-1.pack",
+			"main.go", "sub/.bzr/branch-format", "sub/.github/workflows/ci.yml", "sub/.hg/store/data.i", "sub/.svn/entries",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tree)
+			setFlags(t, tt.flags)
+			var got []string
+			err := handleDir(dir, func(path string) error {
+				rel, err := filepath.Rel(dir, path)
+				got = append(got, filepath.ToSlash(rel))
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVCSDirsLeftUntouched(t *testing.T) {
+	dir := t.TempDir()
+	objects := map[string]string{
+		".git/config":                   "[core]\r\n",
+		".git/objects/pack/pack-1.pack": "PACK\r\n",
+		".hg/store/data.i":              "x\r\n",
+	}
+	writeFiles(t, dir, objects)
+	writeFiles(t, dir, map[string]string{"notes.txt": "a\r\n"})
+	chdir(t, dir)
+	setFlags(t, map[string]string{"hidden": "true", "no-cache": "true"})
+	// walked directories and recursive globs both leave them out
+	paths, err := expandPatterns([]string{".", filepath.FromSlash("**/*")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		if err := handlePath(path, processFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := readFile(t, "notes.txt"); got != "a\n" {
+		t.Errorf("notes.txt wasn't normalized: %q", got)
+	}
+	for name, content := range objects {
+		if got := readFile(t, filepath.FromSlash(name)); got != content {
+			t.Errorf("%s was changed to %q", name, got)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"strings"
+)
+
+var filesFrom = flag.String("files-from", "", "process exactly the files listed in this `file`, or stdin for -, one per line, instead of the path arguments. Nothing is walked or expanded and directories in the list are skipped")
+var filesFromNull = flag.Bool("0", false, "with --files-from, the paths are separated by NUL instead of newline, as printed by git ls-files -z or find -print0")
+
+// readFileList reads the --files-from list.
+func readFileList(name string) ([]string, error) {
+	input := io.Reader(os.Stdin)
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		input = file
+	}
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(nil, 1<<20)
+	if *filesFromNull {
+		scanner.Split(scanNUL)
+	}
+	var paths []string
+	for scanner.Scan() {
+		path := scanner.Text()
+		if !*filesFromNull {
+			path = strings.TrimSuffix(path, "\r")
+		}
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths, scanner.Err()
+}
+
+// scanNUL is a bufio.SplitFunc for NUL separated records.
+func scanNUL(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
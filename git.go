@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// git runs a git command in dir and returns its stdout.
+func git(dir string, stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// gitTopLevel returns the root of the working tree containing dir.
+func gitTopLevel(dir string) (string, error) {
+	out, err := git(dir, nil, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// runStaged normalizes the staged version of every added, copied, modified or
+// renamed file in the index. When the working tree copy matches what's staged
+// it is rewritten and re-added; otherwise (a partially staged file) only the
+// index entry is updated so unstaged edits are left alone.
+func runStaged() error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	top, err := gitTopLevel(wd)
+	if err != nil {
+		log.Warn("not in a git repository, nothing to do for --staged", "error", err)
+		return nil
+	}
+	out, err := git(top, nil, "diff", "--cached", "--name-only", "-z", "--diff-filter=ACMR")
+	if err != nil {
+		return err
+	}
+	for _, name := range strings.Split(string(out), "\x00") {
+		if name == "" {
+			continue
+		}
+		if err := handleStaged(top, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func handleStaged(top, name string) error {
+	entry, err := git(top, nil, "ls-files", "--stage", "-z", "--", name)
+	if err != nil {
+		return err
+	}
+	// <mode> SP <object> SP <stage> TAB <path>
+	fields := strings.Fields(string(entry))
+	if len(fields) < 2 {
+		return fmt.Errorf("unexpected ls-files output for %s", name)
+	}
+	mode := fields[0]
+	if mode == "120000" || mode == "160000" {
+		// symlink or submodule
+		return nil
+	}
+	blob, err := git(top, nil, "cat-file", "blob", fields[1])
+	if err != nil {
+		return err
+	}
+	d, err := detectReader(bytes.NewReader(blob))
+	if err != nil {
+		return err
+	}
+	if !d.isText {
+		return nil
+	}
+	if !supportedEncodings.Contains(strings.ToUpper(d.encoding)) {
+		log.Info("skipping unsupported encoding", "path", name, "encoding", d.encoding)
+		return nil
+	}
+	rw := newLineRewriter()
+	rw.setEncoding(d.encoding)
+	rw.setPath(name)
+	if *preserveStyle {
+		if rw.eol, err = dominantEOL(decoded(d.encoding, blob), rw.eol); err != nil {
+			return err
+		}
+	}
+	var fixed bytes.Buffer
+	if err := rw.encodedRewrite(d.encoding)(bytes.NewReader(blob), &fixed); err != nil {
+		return err
+	}
+	if bytes.Equal(fixed.Bytes(), blob) {
+		return nil
+	}
+	log.Info("replacing staged lines", "path", name, "encoding", d.encoding)
+	if *dryRun {
+		return nil
+	}
+
+	path := filepath.Join(top, filepath.FromSlash(name))
+	working, err := os.ReadFile(path)
+	if err == nil && bytes.Equal(working, blob) {
+		if _, err := safeFileRewrite(path, rw.encodedRewrite(d.encoding)); err != nil {
+			return err
+		}
+		_, err = git(top, nil, "add", "--", name)
+		return err
+	}
+
+	log.Warn("working tree differs from index, only updating the staged copy", "path", name)
+	sha, err := git(top, fixed.Bytes(), "hash-object", "-w", "--stdin", "--path", name)
+	if err != nil {
+		return err
+	}
+	_, err = git(top, nil, "update-index", "--cacheinfo", fmt.Sprintf("%s,%s,%s", mode, strings.TrimSpace(string(sha)), name))
+	return err
+}
+
+// lineRanges is a set of inclusive 1-based line ranges.
+type lineRanges [][2]int
+
+func (r lineRanges) contains(line int) bool {
+	for _, rng := range r {
+		if line >= rng[0] && line <= rng[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// changedLines returns the lines of the working tree copy of path that were
+// added or modified relative to base, according to a zero-context git diff.
+func changedLines(path, base string) (lineRanges, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	out, err := git(filepath.Dir(abs), nil, "diff", "--no-color", "--no-ext-diff", "-U0", base, "--", abs)
+	if err != nil {
+		return nil, err
+	}
+	return parseHunks(out)
+}
+
+// parseHunks extracts the new-file side of every "@@ -a,b +c,d @@" hunk
+// header in a unified diff.
+func parseHunks(diff []byte) (lineRanges, error) {
+	var ranges lineRanges
+	for _, line := range bytes.Split(diff, []byte("\n")) {
+		if !bytes.HasPrefix(line, []byte("@@ ")) {
+			continue
+		}
+		fields := strings.Fields(string(line))
+		if len(fields) < 3 || !strings.HasPrefix(fields[2], "+") {
+			return nil, fmt.Errorf("malformed hunk header: %q", line)
+		}
+		start, count := fields[2][1:], "1"
+		if i := strings.IndexByte(start, ','); i >= 0 {
+			start, count = start[:i], start[i+1:]
+		}
+		s, err := strconv.Atoi(start)
+		if err != nil {
+			return nil, fmt.Errorf("malformed hunk header: %q", line)
+		}
+		c, err := strconv.Atoi(count)
+		if err != nil {
+			return nil, fmt.Errorf("malformed hunk header: %q", line)
+		}
+		if c == 0 {
+			// pure deletion, nothing on the new side
+			continue
+		}
+		ranges = append(ranges, [2]int{s, s + c - 1})
+	}
+	return ranges, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// globRecursive is filepath.Glob with support for "**", which matches any
+// number of directories, including none. Other patterns are passed to
+// filepath.Glob unchanged.
+//
+// The walk starts at the longest leading part of the pattern without glob
+// characters and, like walking a directory, leaves out hidden paths, excluded
+// directories and the paths excluded by ignore files. A matching directory is
+// returned without looking for matches inside it, since its files are
+// processed when it's walked anyway.
+func globRecursive(pattern string) ([]string, error) {
+	if !strings.Contains(pattern, "**") {
+		return filepath.Glob(pattern)
+	}
+	segments := strings.Split(filepath.ToSlash(pattern), "/")
+	static := 0
+	for static < len(segments) && !strings.ContainsAny(segments[static], "*?[") {
+		static++
+	}
+	base := filepath.FromSlash(strings.Join(segments[:static], "/"))
+	switch {
+	case base == "" && strings.HasPrefix(filepath.ToSlash(pattern), "/"):
+		base = string(filepath.Separator)
+	case base == "":
+		base = "."
+	}
+	re, err := regexp.Compile("^" + ignorePatternExpr(strings.Join(segments[static:], "/")) + "$")
+	if err != nil {
+		return nil, filepath.ErrBadPattern
+	}
+	ig := newIgnorer(base)
+	var matches []string
+	err = filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// like filepath.Glob, unreadable directories just don't match
+			if path == base {
+				return nil
+			}
+			return filepath.SkipDir
+		}
+		if path == base {
+			return nil
+		}
+		if isHidden(path) || ig.matches(path, d.IsDir()) || (d.IsDir() && isExcludedDir(base, path)) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		if re.MatchString(filepath.ToSlash(rel)) {
+			matches = append(matches, path)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	return matches, err
+}
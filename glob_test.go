@@ -0,0 +1,77 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExpandPatterns(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.go":             "",
+		"b.go":             "",
+		"[x].go":           "",
+		"notes.txt":        "",
+		"src/c.go":         "",
+		"src/sub/d.go":     "",
+		"src/.hidden/e.go": "",
+	})
+	chdir(t, dir)
+	tests := []struct {
+		noGlob   string
+		patterns []string
+		want     []string
+	}{
+		{"false", []string{"*.go"}, []string{"[x].go", "a.go", "b.go"}},
+		{"false", []string{"src/**/*.go"}, []string{"src/c.go", "src/sub/d.go"}},
+		{"false", []string{"[x].go"}, nil},
+		{"false", []string{"*.md"}, nil},
+		{"false", []string{"notes.txt", "src/*.go"}, []string{"notes.txt", "src/c.go"}},
+		{"true", []string{"*.go"}, []string{"*.go"}},
+		{"true", []string{"[x].go", "a.go"}, []string{"[x].go", "a.go"}},
+		{"true", []string{"src/**/*.go"}, []string{"src/**/*.go"}},
+	}
+	for _, tt := range tests {
+		t.Run("no-glob="+tt.noGlob+" "+strings.Join(tt.patterns, " "), func(t *testing.T) {
+			setFlags(t, map[string]string{"no-glob": tt.noGlob})
+			patterns := make([]string, len(tt.patterns))
+			for i, p := range tt.patterns {
+				patterns[i] = filepath.FromSlash(p)
+			}
+			got, err := expandPatterns(patterns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range got {
+				got[i] = filepath.ToSlash(got[i])
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLiteralPathWithGlobCharacters(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"[x].txt": "a\r\n", "x.txt": "a\r\n"})
+	chdir(t, dir)
+	setFlags(t, map[string]string{"no-glob": "true", "no-cache": "true"})
+	paths, err := expandPatterns([]string{"[x].txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		if err := handlePath(path, processFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := readFile(t, "[x].txt"); got != "a\n" {
+		t.Errorf("the literal path wasn't processed: %q", got)
+	}
+	if got := readFile(t, "x.txt"); got != "a\r\n" {
+		t.Errorf("the path the pattern matches was processed: %q", got)
+	}
+}
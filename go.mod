@@ -5,11 +5,10 @@ go 1.23.0
 toolchain go1.23.10
 
 require (
+	github.com/fsnotify/fsnotify v1.8.0
 	github.com/wlynxg/chardet v1.0.1
 	github.com/wyattis/z v0.12.9
+	golang.org/x/text v0.21.0
 )
 
-require (
-	go.uber.org/multierr v1.11.0 // indirect
-	go.uber.org/zap v1.27.0 // indirect
-)
+require golang.org/x/sys v0.13.0 // indirect
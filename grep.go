@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+)
+
+var grep = flag.String("grep", "", "only process files whose content matches this `regex`. Only the bytes probed for encoding detection are searched unless --probe-full is set")
+var grepInvert = flag.Bool("grep-invert", false, "with --grep, process the files that don't match instead")
+var probeFull = flag.Bool("probe-full", false, "with --grep, search the whole file instead of just the probed prefix")
+
+const reasonGrep = "grep"
+
+// grepPattern is the compiled --grep pattern, nil when it isn't set.
+var grepPattern *regexp.Regexp
+
+func parseGrep(value string) (*regexp.Regexp, error) {
+	if value == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --grep: %w", err)
+	}
+	return re, nil
+}
+
+// grepMatches reports whether path should be processed under --grep and
+// --grep-invert. It searches the bytes d was detected from, reading the
+// prefix itself when detection was skipped (e.g. by --assume).
+func grepMatches(path string, d detection) (bool, error) {
+	content := d.probe
+	if *probeFull || content == nil {
+		file, err := os.Open(path)
+		if err != nil {
+			return false, err
+		}
+		defer file.Close()
+		var r io.Reader = file
+		if !*probeFull {
+			r = io.LimitReader(file, int64(*probeSize)*int64(*maxChunks))
+		}
+		if content, err = io.ReadAll(r); err != nil {
+			return false, err
+		}
+	}
+	return grepPattern.Match(content) != *grepInvert, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// encodingPriority controls the order in which encoding groups are processed
+// by --group-by-encoding. Encodings not listed here sort after these,
+// alphabetically, and files that weren't detected as text come last.
+var encodingPriority = []string{"ASCII", "UTF-8"}
+
+// handleGrouped runs a detection pre-pass over files and then processes them
+// grouped by detected encoding. Each file is read twice: once here to classify
+// it and again by handleFile.
+func handleGrouped(files []string) error {
+	encodings := make(map[string]string, len(files))
+	for _, path := range files {
+		isText, encoding, err := isTextFile(path)
+		if err != nil {
+			return err
+		}
+		if isText {
+			encodings[path] = strings.ToUpper(encoding)
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return encodingLess(encodings[files[i]], encodings[files[j]])
+	})
+	for _, path := range files {
+		log.Debug("processing group", "encoding", encodings[path], "path", path)
+		if err := processFile(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodingLess(a, b string) bool {
+	ra, rb := encodingRank(a), encodingRank(b)
+	if ra != rb {
+		return ra < rb
+	}
+	return a < b
+}
+
+func encodingRank(encoding string) int {
+	if encoding == "" {
+		return len(encodingPriority) + 1
+	}
+	for i, e := range encodingPriority {
+		if e == encoding {
+			return i
+		}
+	}
+	return len(encodingPriority)
+}
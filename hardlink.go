@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+)
+
+var hardlinks = flag.String("hardlinks", hardlinksWarn, "how to rewrite files with more than one hard link: warn (replace the file atomically, which detaches it from its other links), inplace (overwrite the shared content, not atomic) or skip")
+
+// --hardlinks policies
+const (
+	hardlinksWarn    = "warn"
+	hardlinksInPlace = "inplace"
+	hardlinksSkip    = "skip"
+)
+
+// reasonHardlink is the skip reason for files with several links under
+// --hardlinks=skip.
+const reasonHardlink = "hardlink"
+
+// replaceFile moves the rewritten tmpPath over path. Renaming gives a new inode
+// to path, so a file with other hard links would silently stop sharing
+// content with them; depending on --hardlinks that's either reported or
+// avoided by copying the new content into the existing inode instead.
+func replaceFile(tmpPath, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if links := linkCount(info); links > 1 {
+		if *hardlinks == hardlinksInPlace {
+			log.Debug("overwriting hardlinked file in place", "path", path, "links", links)
+			return copyInPlace(tmpPath, path)
+		}
+		log.Warn("replacing hardlinked file, other links keep the old content", "path", path, "links", links)
+	}
+	log.Debug("renaming temporary file", "path", tmpPath, "to", path)
+	return os.Rename(tmpPath, path)
+}
+
+// copyInPlace overwrites the content of path with tmpPath and removes tmpPath.
+func copyInPlace(tmpPath, path string) error {
+	src, err := os.Open(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	src.Close()
+	return os.Remove(tmpPath)
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// linkCount returns the number of hard links to the file described by info.
+// Link counts aren't available on this platform, so every file is treated as
+// having a single link.
+func linkCount(info os.FileInfo) uint64 {
+	return 1
+}
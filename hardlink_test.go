@@ -0,0 +1,57 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHardlinkedFile(t *testing.T) {
+	tests := []struct {
+		policy     string
+		want       string
+		wantOther  string
+		sameInode  bool
+		skipReason string
+	}{
+		{hardlinksInPlace, "a\nb\n", "a\nb\n", true, ""},
+		{hardlinksSkip, "a\r\nb\r\n", "a\r\nb\r\n", true, reasonHardlink},
+		// the documented cost of the atomic rename: the other name keeps the
+		// old inode and content
+		{hardlinksWarn, "a\nb\n", "a\r\nb\r\n", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			setFlags(t, map[string]string{"hardlinks": tt.policy, "assume": ".txt=utf-8", "no-cache": "true"})
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"file.txt": "a\r\nb\r\n"})
+			path, other := filepath.Join(dir, "file.txt"), filepath.Join(dir, "other.txt")
+			if err := os.Link(path, other); err != nil {
+				t.Fatal(err)
+			}
+			res := handleFile(path)
+			if res.Err != nil || res.SkippedReason != tt.skipReason {
+				t.Fatalf("got error %v, skipped %q, want skipped %q", res.Err, res.SkippedReason, tt.skipReason)
+			}
+			if got := readFile(t, path); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if got := readFile(t, other); got != tt.wantOther {
+				t.Errorf("the other link has %q, want %q", got, tt.wantOther)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			otherInfo, err := os.Stat(other)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if same := os.SameFile(info, otherInfo); same != tt.sameInode {
+				t.Errorf("both names share an inode: %v, want %v", same, tt.sameInode)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/wyattis/z/zset/zstringset"
+)
+
+var warnHeredoc = flag.Bool("warn-heredoc", true, "warn when normalizing a shell script would change line endings inside a heredoc")
+
+var shellExtensions = zstringset.New(".sh", ".bash", ".zsh", ".ksh")
+
+// heredocStart matches the redirection that opens a heredoc: <<WORD, <<-WORD,
+// <<'WORD' or <<"WORD", but not the <<< here-string.
+var heredocStart = regexp.MustCompile(`(?:^|[^<])<<(-?)[ \t]*(?:'([^']*)'|"([^"]*)"|\\?([A-Za-z_][A-Za-z0-9_]*))`)
+
+func isShellScript(path string) bool {
+	return shellExtensions.Contains(strings.ToLower(filepath.Ext(path)))
+}
+
+// heredocTracker follows heredoc bodies in a shell script line by line. It's
+// a plain line-based state machine: it doesn't understand quoting, comments
+// or command substitution, so a "<<EOF" inside a string is treated as a real
+// heredoc.
+type heredocTracker struct {
+	// pending holds the heredocs opened on the current command line, in
+	// order, as their terminators. A leading "-" means tabs are stripped
+	// before comparing (<<-).
+	pending []string
+	// crlfLines are the line numbers inside a heredoc body that end in CRLF.
+	crlfLines []int
+}
+
+// observe feeds the raw line n, terminator included, to the tracker.
+func (h *heredocTracker) observe(n int, raw []byte) {
+	line := string(trimEOL(raw))
+	if len(h.pending) > 0 {
+		term := h.pending[0]
+		candidate := line
+		if strings.HasPrefix(term, "-") {
+			term = term[1:]
+			candidate = strings.TrimLeft(candidate, "\t")
+		}
+		if candidate == term {
+			h.pending = h.pending[1:]
+			return
+		}
+		if bytes.HasSuffix(raw, []byte("\r\n")) {
+			h.crlfLines = append(h.crlfLines, n)
+		}
+		return
+	}
+	for _, m := range heredocStart.FindAllStringSubmatch(line, -1) {
+		word := m[2] + m[3] + m[4]
+		if word == "" {
+			continue
+		}
+		h.pending = append(h.pending, m[1]+word)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHeredocCRLF(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		lines  []int
+	}{
+		{"unquoted", "cat <<EOF\r\nbody\r\nmore\r\nEOF\r\necho done\r\n", []int{2, 3}},
+		{"quoted", "cat <<'EOF'\nkeep\r\nEOF\necho\r\n", []int{2}},
+		{"double quoted", "cat <<\"END\"\nkeep\r\nEND\n", []int{2}},
+		{"tab stripped", "\tcat <<-EOF\n\tbody\r\n\tEOF\r\necho\r\n", []int{2}},
+		{"two on one line", "paste <<A <<B\na\r\nA\nb\r\nB\n", []int{2, 4}},
+		{"here-string", "cat <<<word\r\necho\r\n", nil},
+		{"LF body", "cat <<EOF\nbody\nEOF\r\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := lineRewriter{eol: "\n", maxBlankLines: -1, heredocs: &heredocTracker{}}
+			// heredoc bodies are only reported, they're normalized like
+			// everything else
+			if got, want := rewriteString(t, &rw, tt.script), strings.ReplaceAll(tt.script, "\r\n", "\n"); got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if !slices.Equal(rw.heredocs.crlfLines, tt.lines) {
+				t.Errorf("got CRLF heredoc lines %v, want %v", rw.heredocs.crlfLines, tt.lines)
+			}
+		})
+	}
+}
+
+func TestHeredocWarning(t *testing.T) {
+	const script = "#!/bin/sh\r\ncat <<'EOF'\r\nintentional\r\nEOF\r\n"
+	tests := []struct {
+		name string
+		file string
+		eol  string
+		warn bool
+	}{
+		{"shell script", "run.sh", "lf", true},
+		{"not a shell script", "run.txt", "lf", false},
+		{"CRLF kept", "run.sh", "crlf", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t, map[string]string{"eol": tt.eol, "assume": tt.file + "=utf-8", "no-cache": "true"})
+			var logs bytes.Buffer
+			defer func(l *slog.Logger) { log = l }(log)
+			log = slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelWarn}))
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{tt.file: script})
+			if res := handleFile(filepath.Join(dir, tt.file)); res.Err != nil {
+				t.Fatal(res.Err)
+			}
+			if warned := strings.Contains(logs.String(), "inside heredoc"); warned != tt.warn {
+				t.Errorf("warned %v, want %v: %s", warned, tt.warn, logs.String())
+			} else if tt.warn && !strings.Contains(logs.String(), "lines=[3]") {
+				t.Errorf("the warning doesn't name line 3: %s", logs.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"unicode/utf8"
+
+	"github.com/wlynxg/chardet"
+)
+
+var detectStrategy = flag.String("detect", detectChardet, "how to tell text files from binary ones: chardet, heuristic (git's rule: a NUL byte in the first 8000 bytes means binary, and so do more than a few control characters) or both (chardet, with the heuristic deciding the files chardet isn't confident about)")
+
+// --detect strategies
+const (
+	detectChardet   = "chardet"
+	detectHeuristic = "heuristic"
+	detectBoth      = "both"
+)
+
+// heuristicSize is how much of a file the heuristic looks at, the same as
+// git's binary check.
+const heuristicSize = 8000
+
+// heuristicDetection classifies the content of file with looksLikeText
+// instead of the detector, which is only used to name encodings that aren't
+// UTF-8.
+func heuristicDetection(file io.Reader) (d detection, err error) {
+	buf := make([]byte, heuristicSize)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return d, err
+	}
+	d.bytesRead = int64(n)
+	d.probe = buf[:n]
+	if n == 0 {
+		d.reason = reasonBinary
+		return d, nil
+	}
+	if encoding := bomEncoding(d.probe); encoding != "" {
+		d.isText, d.encoding, d.confidence = true, encoding, 1
+		return d, nil
+	}
+	if contentType := binaryContentType(d.probe); contentType != "" {
+		log.Debug("binary content type", "type", contentType)
+		d.reason = reasonBinary
+		return d, nil
+	}
+	if !looksLikeText(d.probe) {
+		log.Debug("heuristic found binary content", "bytes", n)
+		d.reason = reasonBinary
+		return d, nil
+	}
+	d.isText, d.confidence = true, 1
+	if d.encoding = utf8Name(d.probe); d.encoding == "" {
+		detector := chardet.NewUniversalDetector(0)
+		detector.Feed(d.probe)
+		result := detector.GetResult()
+		d.encoding, d.confidence, d.language = result.Encoding, result.Confidence, result.Language
+		if d.encoding == "" {
+			d.isText, d.reason = false, reasonInconclusive
+		}
+	}
+	return d, nil
+}
+
+// looksLikeText applies git's heuristic to probe: any NUL byte means binary,
+// and so does more than one control character per 128 printable bytes.
+// Bytes from 0x80 up count as printable, whatever the encoding.
+func looksLikeText(probe []byte) bool {
+	if bytes.IndexByte(probe, 0) >= 0 {
+		return false
+	}
+	var printable, control int
+	for _, b := range probe {
+		switch {
+		case b == 0x7F:
+			control++
+		case b >= 0x20:
+			printable++
+		case b == '\t', b == '\n', b == '\r', b == '\b', b == '\f', b == '\v', b == 0x1B:
+			printable++
+		default:
+			control++
+		}
+	}
+	return printable>>7 >= control
+}
+
+// smallFileEncoding returns "Ascii" or "UTF-8" for the whole content of a file
+// smaller than the probe, if it's valid as either and passes looksLikeText.
+// The detector rarely gets confident about a few bytes of UTF-8, so without
+// this short files were skipped.
+func smallFileEncoding(content []byte) string {
+	if !utf8.Valid(content) || !looksLikeText(content) {
+		return ""
+	}
+	return utf8Name(content)
+}
+
+// iso2022Escapes start the escape sequences that switch character sets in
+// ISO-2022-JP and its relatives, whose content is otherwise 7-bit.
+var iso2022Escapes = [][]byte{{0x1B, '$'}, {0x1B, '('}, {0x1B, ')'}}
+
+// utf8Name returns "Ascii" or "UTF-8", spelled like the detector does, if
+// probe is valid as either, allowing for a multibyte sequence cut off at its
+// end, or "" otherwise. Content with ISO-2022 escape sequences is neither.
+func utf8Name(probe []byte) string {
+	for _, esc := range iso2022Escapes {
+		if bytes.Contains(probe, esc) {
+			return ""
+		}
+	}
+	ascii := true
+	for len(probe) > 0 {
+		r, size := utf8.DecodeRune(probe)
+		if r == utf8.RuneError && size == 1 {
+			// an incomplete sequence is fine at the end of the probe only
+			if len(probe) < utf8.UTFMax && !utf8.FullRune(probe) {
+				break
+			}
+			return ""
+		}
+		if size > 1 {
+			ascii = false
+		}
+		probe = probe[size:]
+	}
+	if ascii {
+		return "Ascii"
+	}
+	return "UTF-8"
+}
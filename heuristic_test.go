@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSmallFileEncoding(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"a", "Ascii"},
+		{"x = 1\r\n", "Ascii"},
+		{"\n", "Ascii"},
+		{"é", "UTF-8"},
+		{"日本\r\n", "UTF-8"},
+		{"\xef\xbb\xbfa", "UTF-8"},
+		{"\xc3", ""},
+		{"caf\xe9", ""},
+		{"a\x00", ""},
+		{"\x1b$B$\"\x1b(B", ""},
+	}
+	for _, tt := range tests {
+		if got := smallFileEncoding([]byte(tt.content)); got != tt.want {
+			t.Errorf("smallFileEncoding(%q) is %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestTinyFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   map[string]string
+		content string
+		want    string
+		reason  string
+	}{
+		{"single byte", map[string]string{"final-newline": "ensure"}, "a", "a\n", ""},
+		{"single character", map[string]string{"final-newline": "ensure"}, "é", "é\n", ""},
+		{"CRLF line", map[string]string{}, "x\r\n", "x\n", ""},
+		{"only CRLF", map[string]string{}, "\r\n", "\n", ""},
+		{"only CR", map[string]string{}, "\r", "\n", ""},
+		{"only LF", map[string]string{"final-newline": "strip"}, "\n", "", ""},
+		{"BOM", map[string]string{"bom": "strip"}, "\xef\xbb\xbfa\r\n", "a\n", ""},
+		{"BOM only", map[string]string{"bom": "strip"}, "\xef\xbb\xbf", "", ""},
+		{"empty", map[string]string{"final-newline": "ensure"}, "", "", reasonEmpty},
+		{"NUL", map[string]string{}, "\x00", "\x00", reasonBinary},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.flags["no-cache"] = "true"
+			setFlags(t, tt.flags)
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"file.txt": tt.content})
+			path := filepath.Join(dir, "file.txt")
+			res := handleFile(path)
+			if res.Err != nil {
+				t.Fatal(res.Err)
+			}
+			if res.SkippedReason != tt.reason {
+				t.Errorf("SkippedReason is %q, want %q", res.SkippedReason, tt.reason)
+			}
+			if got := readFile(t, path); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
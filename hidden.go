@@ -0,0 +1,16 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"strings"
+)
+
+var hidden = flag.Bool("hidden", false, "also process hidden files and descend into hidden directories, the ones whose name starts with a dot, while walking directories")
+
+// isHidden reports whether path, found while walking, should be left out for
+// being hidden.
+func isHidden(path string) bool {
+	name := filepath.Base(path)
+	return !*hidden && strings.HasPrefix(name, ".") && name != "." && name != ".."
+}
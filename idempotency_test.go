@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/unicode"
+)
+
+// randomEncodings are the encodings randomFiles writes, keyed by the
+// extension of the files using them. Only UTF-8 without a BOM can't be told
+// apart from other encodings reliably, so it's passed with --assume.
+var randomEncodings = map[string]func(string) string{
+	".u8": func(s string) string { return s },
+	".u8bom": func(s string) string {
+		return string(utf8BOM) + s
+	},
+	".u16le": utf16Encoder(unicode.LittleEndian),
+	".u16be": utf16Encoder(unicode.BigEndian),
+}
+
+func utf16Encoder(order unicode.Endianness) func(string) string {
+	return func(s string) string {
+		encoded, err := unicode.UTF16(order, unicode.UseBOM).NewEncoder().String(s)
+		if err != nil {
+			panic(err)
+		}
+		return encoded
+	}
+}
+
+var textWords = []string{"fix", "lines", "café", "naïve", "日本語", "Ünïcödé", "{", "}", "x = 1;", "\tindented"}
+
+// progressWords also have a bare CR inside a line, like a progress bar.
+var progressWords = append(slices.Clip(textWords), "10%\r50%")
+
+// randomText returns a few random lines of words ending in one of eols, with
+// trailing whitespace, blank lines and maybe no line ending at the very end.
+func randomText(r *rand.Rand, words, eols []string) string {
+	var b strings.Builder
+	for range r.Intn(20) + 1 {
+		if r.Intn(5) > 0 {
+			for i := range r.Intn(6) + 1 {
+				if i > 0 {
+					b.WriteString(" ")
+				}
+				b.WriteString(words[r.Intn(len(words))])
+			}
+			b.WriteString([]string{"", "", " ", "\t", "  \t"}[r.Intn(5)])
+		}
+		b.WriteString(eols[r.Intn(len(eols))])
+	}
+	text := b.String()
+	if r.Intn(3) == 0 {
+		text = strings.TrimRight(text, "\r\n")
+	}
+	if text == "" {
+		text = "x"
+	}
+	return text
+}
+
+// randomFiles writes n random files to a new directory and returns it along
+// with their content.
+func randomFiles(t *testing.T, r *rand.Rand, n int, words, eols []string) (string, map[string]string) {
+	t.Helper()
+	exts := []string{".u8", ".u8bom", ".u16le", ".u16be"}
+	files := map[string]string{}
+	for i := range n {
+		ext := exts[r.Intn(len(exts))]
+		files[fmt.Sprintf("file%d%s", i, ext)] = randomEncodings[ext](randomText(r, words, eols))
+	}
+	dir := t.TempDir()
+	writeFiles(t, dir, files)
+	return dir, files
+}
+
+// readTree returns the content of the files in dir, keyed by name.
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, entry := range entries {
+		files[entry.Name()] = readFile(t, filepath.Join(dir, entry.Name()))
+	}
+	return files
+}
+
+// harnessFlags are the configurations the harness runs. Each one has to be
+// idempotent.
+var harnessFlags = []map[string]string{
+	{},
+	{"eol": "crlf"},
+	{"eol": "cr"},
+	{"preserve-style": "true"},
+	{"cr-as-content": "true"},
+	{"final-newline": "ensure"},
+	// stripping the final newline of a file ending in blank lines leaves
+	// the one before, so it's only idempotent with the blank lines trimmed
+	{"final-newline": "strip", "trim-eof-blank-lines": "true"},
+	{"bom": "strip"},
+	{"bom": "add"},
+	{
+		"trim-trailing-whitespace": "true",
+		"final-newline":            "ensure",
+		"max-blank-lines":          "1",
+		"trim-eof-blank-lines":     "true",
+	},
+}
+
+func TestIdempotency(t *testing.T) {
+	for i, flags := range harnessFlags {
+		t.Run(fmt.Sprint(flags), func(t *testing.T) {
+			flags["assume"] = ".u8=utf-8"
+			flags["no-cache"] = "true"
+			setFlags(t, flags)
+			seed := int64(i + 1)
+			r := rand.New(rand.NewSource(seed))
+			dir, _ := randomFiles(t, r, 100, progressWords, []string{"\n", "\r\n", "\r"})
+			if err := processPaths([]string{dir}); err != nil {
+				t.Fatal(err)
+			}
+			once := readTree(t, dir)
+			before := map[string]os.FileInfo{}
+			for name := range once {
+				info, err := os.Stat(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				before[name] = info
+			}
+			if err := processPaths([]string{dir}); err != nil {
+				t.Fatal(err)
+			}
+			twice := readTree(t, dir)
+			for name, want := range once {
+				if got := twice[name]; got != want {
+					t.Errorf("seed %d: %s changed on the second run from %q to %q", seed, name, want, got)
+				}
+				if info, err := os.Stat(filepath.Join(dir, name)); err != nil {
+					t.Fatal(err)
+				} else if !os.SameFile(before[name], info) {
+					t.Errorf("seed %d: %s was rewritten on the second run", seed, name)
+				}
+			}
+		})
+	}
+}
+
+func TestNoOpRoundTrip(t *testing.T) {
+	tests := []struct {
+		flags map[string]string
+		words []string
+		eols  []string
+	}{
+		{map[string]string{}, textWords, []string{"\n"}},
+		{map[string]string{"eol": "crlf"}, textWords, []string{"\r\n"}},
+		{map[string]string{"eol": "cr"}, textWords, []string{"\r"}},
+		{map[string]string{"preserve-style": "true", "eol": "crlf"}, textWords, []string{"\n"}},
+		{map[string]string{"cr-as-content": "true"}, progressWords, []string{"\n"}},
+		{map[string]string{"cr-as-content": "true", "eol": "crlf"}, progressWords, []string{"\r\n"}},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(tt.flags), func(t *testing.T) {
+			tt.flags["assume"] = ".u8=utf-8"
+			tt.flags["no-cache"] = "true"
+			setFlags(t, tt.flags)
+			seed := int64(i + 1)
+			r := rand.New(rand.NewSource(seed))
+			dir, files := randomFiles(t, r, 100, tt.words, tt.eols)
+			if err := processPaths([]string{dir}); err != nil {
+				t.Fatal(err)
+			}
+			for name, got := range readTree(t, dir) {
+				if want := files[name]; got != want {
+					t.Errorf("seed %d: %s changed from %q to %q", seed, name, want, got)
+				}
+			}
+		})
+	}
+}
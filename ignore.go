@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var noIgnore = flag.Bool("no-ignore", false, "don't skip the files and directories excluded by .gitignore, .ignore or .fdignore files")
+var noIgnoreVCS = flag.Bool("no-ignore-vcs", false, "don't read .gitignore files")
+var noIgnoreDot = flag.Bool("no-ignore-dot", false, "don't read .ignore and .fdignore files")
+
+// ignoreFiles returns the files ignore rules are read from in every
+// directory. Later files take precedence, so, like in ripgrep and fd, the
+// generic ones can override .gitignore.
+func ignoreFiles() []string {
+	var names []string
+	if !*noIgnoreVCS {
+		names = append(names, ".gitignore")
+	}
+	if !*noIgnoreDot {
+		names = append(names, ".ignore", ".fdignore")
+	}
+	return names
+}
+
+// ignoreRule is a single pattern from an ignore file.
+type ignoreRule struct {
+	// base is the absolute directory of the ignore file the rule is from.
+	// Patterns are matched against paths relative to it.
+	base    string
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignorer answers whether paths under a root are excluded by the ignore
+// files found there. Rules are read from every directory between the top
+// and the directory of the path being checked, where the top is the root of
+// the git repository the root is in, or the root itself outside of one.
+type ignorer struct {
+	top   string
+	cwd   string
+	files []string
+	// rules caches the rules in effect in an absolute directory: those of
+	// its parents up to top, followed by its own.
+	rules map[string][]ignoreRule
+}
+
+// newIgnorer returns an ignorer for the tree at root, or nil when no ignore
+// files are read. A nil ignorer ignores nothing.
+func newIgnorer(root string) *ignorer {
+	files := ignoreFiles()
+	if *noIgnore || len(files) == 0 {
+		return nil
+	}
+	cwd, _ := os.Getwd()
+	ig := &ignorer{cwd: cwd, files: files, rules: map[string][]ignoreRule{}}
+	ig.top = ig.abs(root)
+	for dir := ig.top; ; {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			ig.top = dir
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return ig
+}
+
+func (ig *ignorer) abs(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(ig.cwd, path)
+}
+
+// matches reports whether path itself is excluded, without looking at its
+// parent directories. That's enough while walking, since the walk doesn't
+// descend into excluded directories to begin with.
+func (ig *ignorer) matches(path string, isDir bool) bool {
+	if ig == nil {
+		return false
+	}
+	abs := ig.abs(path)
+	if abs == ig.top {
+		return false
+	}
+	ignored := false
+	for _, rule := range ig.rulesFor(filepath.Dir(abs)) {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		rel, err := filepath.Rel(rule.base, abs)
+		if err != nil {
+			continue
+		}
+		if rule.re.MatchString(filepath.ToSlash(rel)) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// ignored reports whether path is excluded, either itself or because one of
+// its parent directories below the top is.
+func (ig *ignorer) ignored(path string, isDir bool) bool {
+	if ig == nil {
+		return false
+	}
+	abs := ig.abs(path)
+	rel, err := filepath.Rel(ig.top, abs)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	dir := ig.top
+	parts := strings.Split(rel, string(filepath.Separator))
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		if ig.matches(dir, true) {
+			return true
+		}
+	}
+	return ig.matches(abs, isDir)
+}
+
+// rulesFor returns the rules in effect in the absolute directory dir.
+func (ig *ignorer) rulesFor(dir string) []ignoreRule {
+	if rules, ok := ig.rules[dir]; ok {
+		return rules
+	}
+	var rules []ignoreRule
+	if rel, err := filepath.Rel(ig.top, dir); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+		rules = append(rules, ig.rulesFor(filepath.Dir(dir))...)
+	}
+	for _, name := range ig.files {
+		own, err := readIgnoreFile(filepath.Join(dir, name), dir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Warn("couldn't read ignore file", "path", filepath.Join(dir, name), "error", err)
+		}
+		rules = append(rules, own...)
+	}
+	ig.rules[dir] = rules
+	return rules
+}
+
+// readIgnoreFile parses the gitignore-style file at path, whose patterns are
+// relative to base.
+func readIgnoreFile(path, base string) ([]ignoreRule, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreLine(scanner.Text()); ok {
+			rule.base = base
+			rules = append(rules, rule)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// parseIgnoreLine parses one line of a gitignore file. It reports false for
+// blank lines, comments and patterns that don't compile.
+func parseIgnoreLine(line string) (rule ignoreRule, ok bool) {
+	line = strings.TrimSuffix(line, "\r")
+	// trailing spaces are ignored unless escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false
+	}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return rule, false
+	}
+	// a slash anywhere but at the end anchors the pattern to its directory
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	expr := "^"
+	if !anchored {
+		expr += "(?:.*/)?"
+	}
+	expr += ignorePatternExpr(line) + "$"
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return rule, false
+	}
+	rule.re = re
+	return rule, true
+}
+
+// ignorePatternExpr translates a gitignore glob to a regular expression.
+func ignorePatternExpr(pattern string) string {
+	var expr strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/") && (i == 0 || pattern[i-1] == '/'):
+			// any number of leading directories, including none
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case pattern[i:] == "**" && (i == 0 || pattern[i-1] == '/'):
+			// everything inside the directory, or everything at all
+			expr.WriteString(".+")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				expr.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	return expr.String()
+}
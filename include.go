@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/wyattis/z/zflag"
+	"github.com/wyattis/z/zset/zstringset"
+)
+
+var extFlag = flag.String("ext", "", "comma separated extensions (e.g. go,md,txt) that limit the files found while walking directories to those with one of them")
+var includePatterns = zflag.StringSlice()
+
+func init() {
+	flag.Var(includePatterns, "include", "limit the files found while walking directories to those matching this .gitignore style `pattern`, e.g. '*.go' or 'docs/**'. Patterns containing a slash are matched against the path relative to the directory being walked. Can be repeated or comma separated, and combined with --ext")
+}
+
+// includeExtensions and includeRules are the parsed --ext and --include
+// values. A file has to match either one of them when any are given.
+var includeExtensions = zstringset.New()
+var includeRules []ignoreRule
+
+func parseIncludes(patterns []string) ([]ignoreRule, error) {
+	var rules []ignoreRule
+	for _, pattern := range patterns {
+		rule, ok := parseIgnoreLine(pattern)
+		if !ok || rule.negate || rule.dirOnly {
+			return nil, fmt.Errorf("invalid --include pattern %q", pattern)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// isIncluded reports whether the file at path, found while walking root,
+// passes --ext and --include.
+func isIncluded(root, path string) bool {
+	if includeExtensions.Size() == 0 && len(includeRules) == 0 {
+		return true
+	}
+	if includeExtensions.Contains(strings.ToLower(filepath.Ext(path))) {
+		return true
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	for _, rule := range includeRules {
+		if rule.re.MatchString(rel) {
+			return true
+		}
+	}
+	return false
+}
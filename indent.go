@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"path/filepath"
+	"strings"
+)
+
+var indent = flag.String("indent", "", "also convert leading indentation to `style` tabs or spaces. Makefiles are never converted to spaces")
+var tabWidth = flag.Int("tab-width", 4, "number of columns a tab stands for when converting indentation with --indent")
+
+// --indent styles
+const (
+	indentTabs   = "tabs"
+	indentSpaces = "spaces"
+)
+
+// isMakefile reports whether path is a Makefile, where recipe lines have to
+// be indented with tabs.
+func isMakefile(path string) bool {
+	name := filepath.Base(path)
+	return name == "Makefile" || name == "makefile" || name == "GNUmakefile" || strings.EqualFold(filepath.Ext(name), ".mk")
+}
+
+// reindent rewrites the leading spaces and tabs of line in style, with tabs
+// width columns wide. Tab stops are honored, so "  \t" counts as one tab.
+// Columns that don't add up to a whole tab stay spaces in the tabs style.
+func reindent(line []byte, style string, width int) []byte {
+	columns, i := 0, 0
+	for ; i < len(line) && (line[i] == ' ' || line[i] == '\t'); i++ {
+		if line[i] == '\t' {
+			columns += width - columns%width
+		} else {
+			columns++
+		}
+	}
+	var leading []byte
+	if style == indentTabs {
+		leading = append(bytes.Repeat([]byte("\t"), columns/width), bytes.Repeat([]byte(" "), columns%width)...)
+	} else {
+		leading = bytes.Repeat([]byte(" "), columns)
+	}
+	if bytes.Equal(leading, line[:i]) {
+		return line
+	}
+	return append(leading, line[i:]...)
+}
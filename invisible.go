@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"unicode/utf8"
+)
+
+var stripInvisibles = flag.Bool("strip-invisibles", false, "also remove zero-width and invisible characters (zero-width space, non-joiner and joiner, word joiner, mid-file BOMs) from UTF-8 files")
+
+// invisibles are the characters --strip-invisibles removes. U+FEFF at the very
+// start of a file is a BOM and is left to --bom.
+var invisibles = map[rune]bool{
+	'\u200B': true, // zero width space
+	'\u200C': true, // zero width non-joiner
+	'\u200D': true, // zero width joiner
+	'\u2060': true, // word joiner
+	'\u180E': true, // mongolian vowel separator
+	'\uFEFF': true, // zero width no-break space, i.e. a BOM
+}
+
+func isInvisible(r rune) bool {
+	return invisibles[r]
+}
+
+// withoutInvisibles returns line with the invisible characters removed and
+// how many there were. A BOM at the start of the first line is kept.
+func withoutInvisibles(line []byte, first bool) ([]byte, int) {
+	keep := 0
+	if first && bytes.HasPrefix(line, utf8BOM) {
+		keep = len(utf8BOM)
+	}
+	if !bytes.ContainsFunc(line[keep:], isInvisible) {
+		return line, 0
+	}
+	out := append([]byte(nil), line[:keep]...)
+	removed := 0
+	for i := keep; i < len(line); {
+		r, size := utf8.DecodeRune(line[i:])
+		if invisibles[r] {
+			removed++
+		} else {
+			out = append(out, line[i:i+size]...)
+		}
+		i += size
+	}
+	return out, removed
+}
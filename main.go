@@ -1,22 +1,24 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/wlynxg/chardet"
 	"github.com/wyattis/z/zset/zstringset"
 )
 
-// TODO: Handle other encodings besides UTF-8 and ASCII
-// TODO: Respect .ignore files
-
 var log = slog.Default()
 
 func main() {
@@ -29,90 +31,675 @@ func main() {
 var dryRun = flag.Bool("dry-run", false, "don't actually write any files")
 var verbose = flag.Bool("verbose", false, "verbose logging")
 var probeSize = flag.Int("probe-size", 1024, "how much of each file to probe for encoding")
+var maxChunks = flag.Int("max-chunks", 20, "maximum number of probe-size chunks to read before giving up on detection")
+var minConfidence = flag.Float64("min-confidence", 0.95, "the detector's confidence, between 0 and 1, has to be above this for a file to be treated as text")
+var fallbackConfidence = flag.Float64("fallback-confidence", 0.7, "when the whole probe never got the confidence above --min-confidence, still use the detector's best guess if its confidence is at least this. 0 turns the fallback off")
 var help = flag.Bool("help", false, "show help")
+var scanOnly = flag.Bool("scan-only", false, "only run encoding detection over the matched files and report throughput and confidence statistics")
+var staged = flag.Bool("staged", false, "normalize the content staged in the git index and re-stage the result, for use in a pre-commit hook")
+var emit = flag.Bool("emit", false, "with --dry-run and a single file, print the normalized content to stdout instead of rewriting it")
+var noFollow = flag.Bool("no-follow", false, "skip symlinks passed as arguments too. Symlinks found while walking a directory are skipped unless --follow-symlinks is given, but by default an explicitly passed symlink is followed and its target rewritten")
+var transaction = flag.Bool("transaction", false, "write every file to a temporary copy first and only move them into place once all files succeeded. Needs enough free disk space for a second copy of every changed file")
+var diffBase = flag.String("diff-base", "", "only normalize lines that changed relative to this git `ref`, leaving the rest of each file untouched")
+var emptyFile = flag.String("empty-file", emptyKeep, "what to do with zero-byte files: keep, newline (write a single newline) or remove")
+var hashContent = flag.Bool("hash", false, "report the SHA-256 of each changed file's content before and after normalization")
+var noGlob = flag.Bool("no-glob", false, "treat every argument as a literal path instead of expanding it as a glob pattern, e.g. when the shell already expanded them")
+var groupByEncoding = flag.Bool("group-by-encoding", false, "detect every file up front and process them grouped by encoding (ASCII, then UTF-8, then the rest). Requires two passes over each file")
 
-func run() error {
+func run() (err error) {
 	flag.Parse()
 	if *help {
 		flag.Usage()
 		return nil
 	}
+	if *showVersion {
+		printVersion(os.Stdout)
+		return nil
+	}
 	if *verbose {
-		log = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		logOutput := os.Stdout
+		if *emit || *format == formatSarif || *printSkippedBinary || *report || *encodingsReport || *reportEncodingChanges == detailsJSON {
+			// stdout is reserved for the emitted content
+			logOutput = os.Stderr
+		}
+		log = slog.New(slog.NewTextHandler(logOutput, &slog.HandlerOptions{
 			Level: slog.LevelDebug,
 		}))
 	}
-	roots := flag.Args()
-	if len(roots) == 0 {
-		wd, err := os.Getwd()
-		if err != nil {
+	if err := validateOptions(); err != nil {
+		return err
+	}
+	if *clearCache {
+		if err := removeDetectionCache(); err != nil {
 			return err
 		}
-		roots = []string{wd}
 	}
-	paths, err := expandPatterns(roots)
+	defer func() {
+		if cacheErr := saveDetectionCache(); err == nil {
+			err = cacheErr
+		}
+	}()
+	stopProfiling, err := startProfiling()
 	if err != nil {
 		return err
 	}
+	defer stopProfiling()
+	var paths []string
+	if *filesFrom != "" {
+		if paths, err = readFileList(*filesFrom); err != nil {
+			return err
+		}
+	} else {
+		roots := flag.Args()
+		if len(roots) == 0 {
+			wd, err := os.Getwd()
+			if err != nil {
+				return err
+			}
+			roots = []string{wd}
+		}
+		if paths, err = expandPatterns(roots); err != nil {
+			return err
+		}
+	}
 
-	for _, path := range paths {
-		if err := handlePath(path); err != nil {
+	if *emit {
+		return emitFile(paths)
+	}
+	if *zipOut != "" {
+		return runZip(paths)
+	}
+	if *staged {
+		if *transaction {
+			return errors.New("--transaction can't be combined with --staged")
+		}
+		return runStaged()
+	}
+	if *transaction {
+		defer func() {
+			if err != nil {
+				rollbackPending()
+				return
+			}
+			err = commitPending()
+		}()
+	}
+	if *scanOnly {
+		return runScan(paths)
+	}
+	if *report {
+		return runReport(paths)
+	}
+	if *encodingsReport {
+		return runEncodings(paths)
+	}
+	if *manifest != "" {
+		if len(flag.Args()) > 0 {
+			return errors.New("--manifest can't be combined with path arguments")
+		}
+		if err := runManifest(*manifest); err != nil {
 			return err
 		}
+	} else if err := processPaths(paths); err != nil {
+		return err
 	}
 
+	if *skipReport != "" {
+		if err := writeSkipReport(*skipReport); err != nil {
+			return err
+		}
+	}
+	if *watch {
+		return runWatch(paths)
+	}
+	if *format == formatSarif {
+		return writeSarif(os.Stdout)
+	}
+	if *trimBOMOnly {
+		fmt.Printf("stripped %d BOMs\n", bomsStripped)
+	}
+	if *convertToUTF8 {
+		fmt.Printf("converted %d files to UTF-8\n", filesConverted)
+	}
+	if *reportEncodingChanges != "" {
+		if err := writeEncodingChanges(os.Stdout); err != nil {
+			return err
+		}
+	}
+	if *slowest > 0 {
+		if err := printSlowest(os.Stdout); err != nil {
+			return err
+		}
+	}
+	if *verbose {
+		log.Debug("ran full encoding detection", "files", detectorRuns)
+		return timings.print(os.Stdout)
+	}
 	return nil
 }
 
-func handlePath(path string) error {
-	info, err := os.Stat(path)
+// validateOptions checks and parses flag values that need more than the flag
+// package's own validation.
+func validateOptions() (err error) {
+	switch *format {
+	case formatText:
+	case formatSarif:
+		// the report describes what would change, nothing is written
+		*dryRun = true
+	default:
+		return fmt.Errorf("invalid --format %q, expected text or sarif", *format)
+	}
+	switch *hardlinks {
+	case hardlinksWarn, hardlinksInPlace, hardlinksSkip:
+	default:
+		return fmt.Errorf("invalid --hardlinks %q, expected warn, inplace or skip", *hardlinks)
+	}
+	if _, ok := eolTerminators[*eol]; !ok {
+		return fmt.Errorf("invalid --eol %q, expected lf, crlf, cr or native", *eol)
+	}
+	switch *indent {
+	case "", indentTabs, indentSpaces:
+	default:
+		return fmt.Errorf("invalid --indent %q, expected tabs or spaces", *indent)
+	}
+	if *tabWidth < 1 {
+		return fmt.Errorf("invalid --tab-width %d, expected at least 1", *tabWidth)
+	}
+	switch *bomMode {
+	case bomKeep, bomStrip:
+	case bomAdd:
+		if *stripBOM {
+			return errors.New("--strip-bom can't be combined with --bom=add")
+		}
+	default:
+		return fmt.Errorf("invalid --bom %q, expected keep, add or strip", *bomMode)
+	}
+	switch *finalNewline {
+	case finalNewlineKeep, finalNewlineEnsure, finalNewlineStrip:
+	default:
+		return fmt.Errorf("invalid --final-newline %q, expected keep, ensure or strip", *finalNewline)
+	}
+	switch *emptyFile {
+	case emptyKeep, emptyNewline, emptyRemove:
+	default:
+		return fmt.Errorf("invalid --empty-file %q, expected keep, newline or remove", *emptyFile)
+	}
+	switch *detectDetails {
+	case "":
+	case detailsText, detailsJSON:
+		if !*scanOnly {
+			return errors.New("--detect-details requires --scan-only")
+		}
+	default:
+		return fmt.Errorf("invalid --detect-details %q, expected text or json", *detectDetails)
+	}
+	switch *reportEncodingChanges {
+	case "", detailsText, detailsJSON:
+	default:
+		return fmt.Errorf("invalid --report-encoding-changes %q, expected text or json", *reportEncodingChanges)
+	}
+	if *filesFrom != "" && (len(flag.Args()) > 0 || *staged || *manifest != "") {
+		return errors.New("--files-from can't be combined with path arguments, --staged or --manifest")
+	}
+	if *filesFromNull && *filesFrom == "" {
+		return errors.New("-0 needs --files-from")
+	}
+	if *watch && (*staged || *emit || *transaction || *scanOnly || *manifest != "" || *format == formatSarif) {
+		return errors.New("--watch can't be combined with --staged, --emit, --transaction, --scan-only, --manifest or --format=sarif")
+	}
+	if eolRules, err = parseEOLRules(*eolRulesFlag); err != nil {
+		return err
+	}
+	if assumeRules, err = parseAssume(*assume); err != nil {
+		return err
+	}
+	if excludeRules, err = parseExcludes(excludePatterns.Val()); err != nil {
+		return err
+	}
+	includeExtensions = zstringset.New(splitExtensions(*extFlag)...)
+	if includeRules, err = parseIncludes(includePatterns.Val()); err != nil {
+		return err
+	}
+	switch *detectStrategy {
+	case detectChardet, detectHeuristic, detectBoth:
+	default:
+		return fmt.Errorf("invalid --detect %q, expected chardet, heuristic or both", *detectStrategy)
+	}
+	if *minConfidence < 0 || *minConfidence >= 1 {
+		return fmt.Errorf("invalid --min-confidence %v, expected at least 0 and less than 1", *minConfidence)
+	}
+	if *fallbackConfidence < 0 || *fallbackConfidence > 1 {
+		return fmt.Errorf("invalid --fallback-confidence %v, expected between 0 and 1", *fallbackConfidence)
+	}
+	if *forcedEncoding != "" && !supportedEncodings.Contains(strings.ToUpper(*forcedEncoding)) {
+		return fmt.Errorf("invalid --encoding: unsupported encoding %s", *forcedEncoding)
+	}
+	parseTextExtensions()
+	if assumeTextExtensions = nil; *assumeTextExtensionsFlag != "" {
+		assumeTextExtensions = splitExtensions(*assumeTextExtensionsFlag)
+	}
+	if grepPattern, err = parseGrep(*grep); err != nil {
+		return err
+	}
+	return nil
+}
+
+// processPaths normalizes every file under paths.
+func processPaths(paths []string) error {
+	if *groupByEncoding {
+		var files []string
+		for _, path := range paths {
+			if err := handlePath(path, func(path string) error {
+				files = append(files, path)
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		return handleGrouped(files)
+	}
+	for _, path := range paths {
+		if err := handlePath(path, processFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func handlePath(path string, handle func(path string) error) error {
+	stat := os.Stat
+	if *noFollow {
+		stat = os.Lstat
+	}
+	info, err := stat(path)
 	if err != nil {
 		return err
 	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		log.Info("skipping symlink", "path", path)
+		recordSkip(path, reasonSymlink)
+		return nil
+	}
+	if *filesFrom != "" && info.IsDir() {
+		log.Debug("skipping directory from --files-from", "path", path)
+		return nil
+	}
+	if *filesFrom == "" && isExcludedArg(path, info.IsDir()) {
+		log.Info("skipping excluded path", "path", path)
+		recordSkip(path, reasonExcluded)
+		return nil
+	}
+	if link, err := os.Lstat(path); err == nil && link.Mode()&os.ModeSymlink != 0 {
+		target, err := realPath(path)
+		if err != nil {
+			return err
+		}
+		log.Debug("following symlink", "path", path, "target", target)
+		if info.IsDir() {
+			// WalkDir doesn't follow a symlinked root, so walk the target
+			// and report its files below the link
+			return newDirWalker(path, handle).walk(path, target)
+		}
+		// rewrite the target instead of replacing the link with a copy
+		path = target
+	}
 	if info.IsDir() {
-		return handleDir(path)
+		return handleDir(path, handle)
 	}
-	return handleFile(path)
+	return handle(path)
+}
+
+func handleDir(root string, handle func(path string) error) error {
+	return newDirWalker(root, handle).walk(root, root)
 }
 
-func handleDir(root string) error {
-	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+// walk walks the directory found at walked on disk, reporting the paths below
+// it as if they were below dir. The two only differ for symlinked directories
+// with --follow-symlinks.
+func (dw *dirWalker) walk(dir, walked string) error {
+	root := dw.root
+	return filepath.WalkDir(walked, func(onDisk string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if info.IsDir() {
+		path := dir
+		if onDisk != walked {
+			rel, err := filepath.Rel(walked, onDisk)
+			if err != nil {
+				return err
+			}
+			path = filepath.Join(dir, rel)
+		}
+		if path != root && isHidden(path) {
+			log.Debug("skipping hidden path", "path", path)
+			recordSkip(path, reasonHidden)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
-		if info.Mode()&os.ModeSymlink != 0 {
+		if path != root && dw.ig.matches(path, d.IsDir()) {
+			log.Debug("skipping ignored path", "path", path)
+			recordSkip(path, reasonIgnored)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isExcludedPath(root, path, d.IsDir()) {
+			log.Debug("skipping excluded path", "path", path)
+			recordSkip(path, reasonExcluded)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if isExcludedDir(root, path) {
+				log.Debug("skipping excluded directory", "path", path)
+				recordSkip(path, reasonExcluded)
+				return filepath.SkipDir
+			}
+			if !dw.visitDir(path, onDisk) {
+				log.Debug("skipping directory that was already walked", "path", path)
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			if *followSymlinks {
+				return dw.follow(path)
+			}
+			recordSkip(path, reasonSymlink)
+			return nil
+		}
+		if !isIncluded(root, path) || !dw.visitFile(path) {
 			return nil
 		}
 
-		return handleFile(path)
+		return dw.handle(path)
 	})
 }
 
-func handleFile(path string) error {
-	isText, encoding, err := isTextFile(path)
+func handleFile(path string) (res FileResult) {
+	res.Path = path
+	if *slowest > 0 {
+		start := time.Now()
+		defer func() {
+			recordSlowest(path, time.Since(start))
+		}()
+	}
+	info, err := os.Stat(path)
 	if err != nil {
+		return res.fail(err)
+	}
+	if info.Size() == 0 {
+		changed, err := handleEmptyFile(path)
+		if err != nil {
+			return res.fail(err)
+		}
+		res.Changed = changed
+		if !changed {
+			return res.skipped(reasonEmpty)
+		}
+		return res
+	}
+	if *hardlinks == hardlinksSkip && linkCount(info) > 1 {
+		log.Info("skipping hardlinked file", "path", path)
+		return res.skipped(reasonHardlink)
+	}
+	if reason, err := prefilter(path, info); err != nil {
+		return res.fail(err)
+	} else if reason == reasonTooLarge {
+		// unlike the binary checks this can skip text files, so always say so
+		log.Info("skipping file larger than --max-size", "path", path, "size", info.Size())
+		return res.skipped(reason)
+	} else if reason != "" {
+		log.Debug("skipping file", "path", path, "reason", reason)
+		return res.skipped(reason)
+	}
+	var detectStart, rewriteStart time.Time
+	var detectTime, rewriteTime time.Duration
+	if *verbose {
+		detectStart = time.Now()
+	}
+	d, err := detectForRewrite(path)
+	res.Encoding = d.encoding
+	if *verbose {
+		detectTime = time.Since(detectStart)
+		defer func() {
+			timings.record(res.Encoding, detectTime, rewriteTime)
+		}()
+	}
+	if err != nil {
+		return res.fail(err)
+	}
+	if !d.isText {
+		log.Debug("skipping non-text file", "path", path, "reason", d.reason, "confidence", d.confidence)
+		return res.skipped(d.reason)
+	}
+	if grepPattern != nil {
+		if match, err := grepMatches(path, d); err != nil {
+			return res.fail(err)
+		} else if !match {
+			log.Debug("skipping file not matching --grep", "path", path)
+			return res.skipped(reasonGrep)
+		}
+	}
+	if !supportedEncodings.Contains(strings.ToUpper(res.Encoding)) {
+		log.Info("skipping unsupported encoding", "path", path, "encoding", res.Encoding)
+		return res.skipped(reasonUnsupportedEncoding)
+	}
+	if *onlyMixed {
+		counts, err := countFileEOLs(path, res.Encoding)
+		if errors.Is(err, errInvalidCodeUnits) {
+			return res.invalidContent()
+		} else if err != nil {
+			return res.fail(err)
+		}
+		if !counts.mixed() {
+			log.Debug("skipping file with consistent line endings", "path", path)
+			return res.skipped(reasonNotMixed)
+		}
+	}
+	if *verbose {
+		rewriteStart = time.Now()
+	}
+	var sums contentHashes
+	if *trimBOMOnly {
+		sums, err = trimLeadingBOM(path, res.Encoding)
+	} else {
+		res.Stats, sums, err = replaceLines(path, res.Encoding)
+		if errors.Is(err, errInvalidCodeUnits) {
+			// nothing was written, the temporary copy is removed on error
+			return res.invalidContent()
+		}
+	}
+	res.Changed = sums.changed()
+	res.EncodingChanged = res.Changed && res.Stats.ConvertedFrom != ""
+	if !*trimBOMOnly {
+		res.Signature = res.Stats.signature(res.Changed)
+	}
+	if *hashContent && res.Changed {
+		res.HashBefore, res.HashAfter = hex.EncodeToString(sums.before), hex.EncodeToString(sums.after)
+		log.Info("content hashes", "path", path, "before", res.HashBefore, "after", res.HashAfter)
+	}
+	if *verbose {
+		rewriteTime = time.Since(rewriteStart)
+	}
+	if err != nil {
+		return res.fail(err)
+	}
+	return res
+}
+
+// detectForRewrite returns the detection a rewrite of path goes by: --assume,
+// the text extensions, the detector and --assume-text, in that order.
+func detectForRewrite(path string) (d detection, err error) {
+	d, known, err := assumedDetection(path)
+	if !known && err == nil && (*onlyTextExtensions || *trustExtensions) {
+		if isTextExtension(path) {
+			d, known, err = extensionDetection(path)
+		} else if *onlyTextExtensions {
+			log.Debug("skipping file without a text extension", "path", path)
+			return detection{reason: reasonNotTextExtension}, nil
+		}
+	}
+	if !known && err == nil {
+		d, err = detectFile(path)
+	}
+	if err == nil && !d.isText {
+		d, _ = assumedText(path, d)
+	}
+	return d, err
+}
+
+// --empty-file policies
+const (
+	emptyKeep    = "keep"
+	emptyNewline = "newline"
+	emptyRemove  = "remove"
+)
+
+// handleEmptyFile applies the --empty-file policy to a zero-byte file and
+// reports whether the file was changed.
+func handleEmptyFile(path string) (bool, error) {
+	switch *emptyFile {
+	case emptyNewline:
+		log.Info("adding newline to empty file", "path", path)
+		if *dryRun {
+			return true, nil
+		}
+		if *transaction {
+			_, err := safeFileRewrite(path, func(_ io.Reader, output io.Writer) error {
+				_, err := io.WriteString(output, eolFor(path))
+				return err
+			})
+			return true, err
+		}
+		return true, os.WriteFile(path, []byte(eolFor(path)), 0)
+	case emptyRemove:
+		log.Info("removing empty file", "path", path)
+		if *dryRun {
+			return true, nil
+		}
+		if *transaction {
+			// deleted on commit, so a rollback leaves it in place
+			if !isStaged(path) {
+				stage(pendingRename{path: path, remove: true})
+			}
+			return true, nil
+		}
+		return true, os.Remove(path)
+	default:
+		log.Debug("skipping empty file", "path", path)
+		return false, nil
+	}
+}
+
+// emitFile writes the normalized content of the single file in paths to
+// stdout. Nothing on disk is modified.
+func emitFile(paths []string) error {
+	if !*dryRun {
+		return errors.New("--emit requires --dry-run")
+	}
+	if len(paths) != 1 {
+		return fmt.Errorf("--emit takes exactly one file, got %d", len(paths))
+	}
+	path := paths[0]
+	if info, err := os.Stat(path); err != nil {
 		return err
+	} else if info.IsDir() {
+		return fmt.Errorf("--emit takes a single file, %s is a directory", path)
 	}
-	if !isText {
-		return nil
+	d, err := detectFile(path)
+	if err != nil {
+		return err
 	}
-	if !supportedEncodings.Contains(strings.ToUpper(encoding)) {
-		slog.Info("skipping unsupported encoding", "path", path, "encoding", encoding)
-		return nil
+	if !d.isText {
+		return fmt.Errorf("%s is not a text file (%s)", path, d.reason)
+	}
+	if !supportedEncodings.Contains(strings.ToUpper(d.encoding)) {
+		return fmt.Errorf("%s has unsupported encoding: %s", path, d.encoding)
+	}
+	input, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	rw := newLineRewriter()
+	rw.setEncoding(d.encoding)
+	rw.setPath(path)
+	if *preserveStyle {
+		if rw.eol, err = dominantFileEOL(path, d.encoding, rw.eol); err != nil {
+			return err
+		}
+	}
+	return rw.encodedRewrite(d.encoding)(input, os.Stdout)
+}
+
+// contentHashes are the SHA-256 sums of a file's content before and after it
+// was rewritten.
+type contentHashes struct {
+	before []byte
+	after  []byte
+}
+
+func (h contentHashes) changed() bool {
+	return !bytes.Equal(h.before, h.after)
+}
+
+// dryRewrite runs cb over the contents of path without writing anything and
+// returns the hashes of the input and of what would have been written.
+func dryRewrite(path string, cb func(input io.Reader, output io.Writer) error) (sums contentHashes, err error) {
+	input, err := os.Open(path)
+	if err != nil {
+		return
 	}
-	return replaceLines(path, encoding)
+	defer input.Close()
+	inputHash, outputHash := sha256.New(), sha256.New()
+	teeInput := io.TeeReader(input, inputHash)
+	if err = cb(teeInput, outputHash); err != nil {
+		return
+	}
+	if _, err = io.Copy(io.Discard, teeInput); err != nil {
+		return
+	}
+	return contentHashes{inputHash.Sum(nil), outputHash.Sum(nil)}, nil
 }
 
-func safeFileRewrite(path string, cb func(input, output *os.File) error) (err error) {
+// safeFileRewrite runs cb over the contents of path and atomically replaces
+// the file with the result. When the output is byte-identical to the input the
+// temporary file is discarded and path is left untouched (same inode and
+// mtime), so repeat runs over a normalized tree don't modify anything.
+func safeFileRewrite(path string, cb func(input io.Reader, output io.Writer) error) (sums contentHashes, err error) {
+	if *transaction && isStaged(path) {
+		// the staged copy was made from the same, still unmodified content
+		log.Debug("file already staged", "path", path)
+		return sums, nil
+	}
 	tmpPath := fmt.Sprintf("%s.tmp", path)
 	log.Debug("creating temporary file", "path", tmpPath)
 	tmpFile, err := os.Create(tmpPath)
+	// copyBack is set when the temporary file couldn't be created next to
+	// path and has to be copied into place rather than renamed
+	copyBack := false
+	if errors.Is(err, fs.ErrPermission) {
+		log.Warn("directory isn't writable, falling back to a non-atomic copy through the temp directory", "path", path)
+		tmpFile, err = os.CreateTemp("", filepath.Base(path)+".*.tmp")
+		if err == nil {
+			tmpPath = tmpFile.Name()
+			copyBack = true
+		}
+	}
 	if err != nil {
 		return
 	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmpPath)
+		}
+	}()
 	isTmpClosed := false
 	defer func() {
 		if !isTmpClosed {
@@ -130,7 +717,13 @@ func safeFileRewrite(path string, cb func(input, output *os.File) error) (err er
 			input.Close()
 		}
 	}()
-	if err = cb(input, tmpFile); err != nil {
+	inputHash, outputHash := sha256.New(), sha256.New()
+	teeInput := io.TeeReader(input, inputHash)
+	if err = cb(teeInput, io.MultiWriter(tmpFile, outputHash)); err != nil {
+		return
+	}
+	// make sure the whole input is hashed even if cb stopped early
+	if _, err = io.Copy(io.Discard, teeInput); err != nil {
 		return
 	}
 	log.Debug("closing temporary file", "path", tmpPath)
@@ -142,46 +735,93 @@ func safeFileRewrite(path string, cb func(input, output *os.File) error) (err er
 		return
 	}
 	isInputClosed = true
-	log.Debug("renaming temporary file", "path", tmpPath, "to", path)
-	return os.Rename(tmpPath, path)
+	sums = contentHashes{inputHash.Sum(nil), outputHash.Sum(nil)}
+	if !sums.changed() {
+		log.Debug("file unchanged, removing temporary file", "path", tmpPath)
+		return sums, os.Remove(tmpPath)
+	}
+	if *transaction {
+		stage(pendingRename{tmpPath: tmpPath, path: path, copyBack: copyBack})
+		return sums, nil
+	}
+	if copyBack {
+		return sums, copyInPlace(tmpPath, path)
+	}
+	return sums, replaceFile(tmpPath, path)
 }
 
-func replaceLines(path string, encoding string) error {
-	switch strings.ToUpper(encoding) {
-	case "UTF-8", "ASCII":
+// replaceLines rewrites path in place and reports what it found along with
+// the content hashes before and after.
+func replaceLines(path string, encoding string) (stats LineStats, sums contentHashes, err error) {
+	switch upper := strings.ToUpper(encoding); {
+	case byteSafeEncodings.Contains(upper), decodableEncodings.Contains(upper):
+		rw := newLineRewriter()
+		if *diffBase != "" {
+			ranges, err := changedLines(path, *diffBase)
+			if err != nil {
+				return stats, sums, err
+			}
+			if len(ranges) == 0 {
+				log.Debug("no lines changed since base", "path", path, "base", *diffBase)
+				return stats, sums, nil
+			}
+			rw.include = ranges.contains
+		}
+		rw.setEncoding(encoding)
+		rw.setPath(path)
+		if *preserveStyle {
+			if rw.eol, err = dominantFileEOL(path, encoding, rw.eol); err != nil {
+				return stats, sums, err
+			}
+		}
+		if *warnHeredoc && rw.eol != "\r\n" && isShellScript(path) {
+			rw.heredocs = &heredocTracker{}
+		}
+		if rw.onlyLineEndings() {
+			// a read-only pass to avoid writing a temporary copy of a file
+			// that is already normalized
+			counts, err := countFileEOLs(path, encoding)
+			if err != nil {
+				return stats, sums, err
+			}
+			if !rw.wouldChange(counts) {
+				log.Debug("line endings already normalized", "path", path)
+				return stats, sums, nil
+			}
+		}
 		log.Info("replacing lines", "path", path, "encoding", encoding)
 		if *dryRun {
-			return nil
+			sums, err = dryRewrite(path, rw.encodedRewrite(encoding))
+		} else {
+			sums, err = safeFileRewrite(path, rw.encodedRewrite(encoding))
 		}
-		return safeFileRewrite(path, replaceUtf8)
-	default:
-		return fmt.Errorf("unsupported encoding: %s", encoding)
-	}
-}
-
-func replaceUtf8(input *os.File, output *os.File) error {
-	buf := bufio.NewReader(input)
-	outBuf := bufio.NewWriter(output)
-	scanner := bufio.NewScanner(buf)
-	scanner.Split(bufio.ScanLines)
-	for scanner.Scan() {
-		if scanner.Err() != nil {
-			return scanner.Err()
+		if err == nil {
+			log.Info("line endings", "path", path, "signature", rw.stats.signature(sums.changed()))
+			if rw.convertFrom != "" {
+				filesConverted++
+			}
 		}
-		line := scanner.Text()
-		log.Debug("replacing line", "line", line)
-		outBuf.WriteString(line + "\n")
-	}
-	if scanner.Err() != nil {
-		return scanner.Err()
+		if rw.heredocs != nil && len(rw.heredocs.crlfLines) > 0 {
+			log.Warn("normalizing CRLF inside heredoc", "path", path, "lines", rw.heredocs.crlfLines)
+		}
+		if len(rw.stats.InnerBOMs) > 0 {
+			log.Warn("found BOM in the middle of the file", "path", path, "offsets", rw.stats.InnerBOMs, "stripped", rw.stripInnerBOMs)
+		}
+		return rw.stats, sums, err
+	default:
+		return stats, sums, fmt.Errorf("unsupported encoding: %s", encoding)
 	}
-	return outBuf.Flush()
 }
 
+// expandPatterns expands each glob pattern into the paths it matches. With
+// --no-glob the patterns are returned as is, as literal paths.
 func expandPatterns(patterns []string) ([]string, error) {
+	if *noGlob {
+		return patterns, nil
+	}
 	var paths []string
 	for _, pattern := range patterns {
-		matches, err := filepath.Glob(pattern)
+		matches, err := globRecursive(pattern)
 		if err != nil {
 			return nil, err
 		}
@@ -190,43 +830,151 @@ func expandPatterns(patterns []string) ([]string, error) {
 	return paths, nil
 }
 
-var supportedEncodings = zstringset.New("UTF-8", "ASCII")
-var detector = chardet.NewUniversalDetector(0)
+// Reasons a file wasn't classified as text.
+const (
+	// reasonBinary means the whole file was probed without reaching the
+	// required confidence.
+	reasonBinary = "binary"
+	// reasonInconclusive means the probe limit (--max-chunks) was reached
+	// before the detector became confident. Probing deeper may help.
+	reasonInconclusive = "inconclusive"
+)
+
+// detection is the outcome of probing a file for its encoding.
+type detection struct {
+	isText     bool
+	encoding   string
+	confidence float64
+	// language is the detector's language guess, if it made one.
+	language  string
+	bytesRead int64
+	// probe holds the prefix of the file that was fed to the detector.
+	probe []byte
+	// reason is set when isText is false.
+	reason string
+}
 
 func isTextFile(path string) (isText bool, encoding string, err error) {
+	d, err := detectFile(path)
+	return d.isText, d.encoding, err
+}
+
+func detectFile(path string) (d detection, err error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return
 	}
 	defer file.Close()
+	var info os.FileInfo
+	if useCache() {
+		if info, err = file.Stat(); err != nil {
+			return
+		}
+		// a cached detection has no probe, which --assume-text needs for the
+		// files that didn't come out as text
+		if d, ok := cachedDetection(path, info); ok && (d.isText || !*assumeText) {
+			return d, nil
+		}
+	}
 	log.Debug("checking if file is text", "path", path)
-	return isTextFileReader(file)
+	detectorRuns++
+	if d, err = detectReader(file); err == nil && info != nil {
+		cacheDetection(path, info, d)
+	}
+	return d, err
 }
 
-func isTextFileReader(file io.Reader) (isText bool, encoding string, err error) {
-	detector.Reset()
-	var maxChunks = 20
+func detectReader(file io.Reader) (d detection, err error) {
+	if *forcedEncoding != "" {
+		return forcedDetection(file)
+	}
+	if *detectStrategy == detectHeuristic {
+		return heuristicDetection(file)
+	}
 	var chunk = make([]byte, *probeSize)
-	var requiredConfidence = 0.95
-	for i := 0; i < maxChunks; i++ {
+	var probed []byte
+	var guess string
+	for i := 0; i < *maxChunks; i++ {
 		log.Debug("reading chunk", "chunk", i)
-		n, err := file.Read(chunk)
+		// ReadFull so a reader returning a few bytes per call (slow network
+		// filesystems, pipes) still gets probeSize*maxChunks bytes probed
+		// rather than maxChunks short reads.
+		n, err := io.ReadFull(file, chunk)
 		log.Debug("read chunk", "chunk", i, "n", n, "err", err)
-		if err == io.EOF {
+		d.bytesRead += int64(n)
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if eof {
 			if n == 0 {
+				d.reason = reasonBinary
 				break
 			}
 			log.Debug("EOF w/ data read")
 			err = nil
 		}
 		if err != nil {
-			return false, "", err
+			return d, err
+		}
+		// GetResult finalizes the detector, so each round starts over with
+		// everything probed so far rather than feeding it incrementally. A
+		// new detector is used since Reset leaves some multibyte probers in
+		// the state of the previous file, which made Shift_JIS files that
+		// followed another file come out with zero confidence.
+		probed = append(probed, chunk[:n]...)
+		d.probe = probed
+		if i == 0 {
+			if encoding := bomEncoding(probed); encoding != "" {
+				log.Debug("found BOM", "encoding", encoding)
+				d.isText, d.encoding, d.confidence = true, encoding, 1
+				return d, nil
+			}
+			if contentType := binaryContentType(probed); contentType != "" {
+				log.Debug("binary content type", "type", contentType)
+				d.reason = reasonBinary
+				return d, nil
+			}
+			if eof {
+				if encoding := smallFileEncoding(probed); encoding != "" {
+					log.Debug("small file is text", "encoding", encoding, "bytes", n)
+					d.isText, d.encoding, d.confidence = true, encoding, 1
+					return d, nil
+				}
+			}
 		}
-		detector.Feed(chunk[:n])
+		detector := chardet.NewUniversalDetector(0)
+		detector.Feed(probed)
 		result := detector.GetResult()
-		if result.Confidence > requiredConfidence {
-			return true, result.Encoding, nil
+		d.confidence = result.Confidence
+		d.language = result.Language
+		guess = result.Encoding
+		log.Debug("detection result", "chunk", i, "encoding", result.Encoding, "confidence", result.Confidence)
+		if result.Confidence > *minConfidence {
+			d.isText = true
+			d.encoding = result.Encoding
+			return d, nil
+		}
+	}
+	if d.reason == "" {
+		d.reason = reasonInconclusive
+	}
+	// the probe is exhausted, settle for the best guess if it's good enough
+	if guess != "" && *fallbackConfidence > 0 && d.confidence >= *fallbackConfidence {
+		log.Debug("using the detector's best guess", "encoding", guess, "confidence", d.confidence, "bytes", d.bytesRead)
+		d.isText = true
+		d.encoding = guess
+		d.reason = ""
+		return d, nil
+	}
+	if *detectStrategy == detectBoth && len(probed) > 0 && looksLikeText(probed) {
+		if d.encoding = utf8Name(probed); d.encoding == "" {
+			d.encoding = guess
+		}
+		if d.encoding != "" {
+			log.Debug("heuristic overrides the detector", "encoding", d.encoding, "confidence", d.confidence)
+			d.isText = true
+			d.reason = ""
+			return d, nil
 		}
 	}
-	return false, "", nil
+	log.Debug("no confident detection", "reason", d.reason, "guess", guess, "confidence", d.confidence, "bytes", d.bytesRead)
+	return d, nil
 }
@@ -0,0 +1,562 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/wyattis/z/zflag"
+	"golang.org/x/text/encoding/charmap"
+)
+
+func TestMain(m *testing.M) {
+	// keep the detection cache of the tests away from the user's
+	cacheDir, err := os.MkdirTemp("", "fix-lines-test-cache")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("XDG_CACHE_HOME", cacheDir)
+	os.Setenv("HOME", cacheDir)
+	log = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	code := m.Run()
+	os.RemoveAll(cacheDir)
+	os.Exit(code)
+}
+
+// setFlags sets the given flags for the rest of the test and runs
+// validateOptions, so the values derived from them are up to date too.
+// Everything is restored when the test ends.
+func setFlags(t *testing.T, values map[string]string) {
+	t.Helper()
+	t.Cleanup(func() {
+		if err := validateOptions(); err != nil {
+			t.Errorf("restoring flags: %v", err)
+		}
+	})
+	for name, value := range values {
+		f := flag.Lookup(name)
+		if f == nil {
+			t.Fatalf("no flag %q", name)
+		}
+		if slice, ok := f.Value.(*zflag.StringSliceVar); ok {
+			saved := *slice
+			t.Cleanup(func() { *slice = saved })
+		} else {
+			old := f.Value.String()
+			t.Cleanup(func() { f.Value.Set(old) })
+		}
+		if err := f.Value.Set(value); err != nil {
+			t.Fatalf("setting --%s=%s: %v", name, value, err)
+		}
+	}
+	if err := validateOptions(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeFiles creates the files in content, keyed by their slash separated
+// path, below dir.
+func writeFiles(t *testing.T, dir string, content map[string]string) {
+	t.Helper()
+	for name, data := range content {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readFile returns the content of path as a string.
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+// chdir changes the working directory to dir for the rest of the test.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestConformingLinesUnchanged(t *testing.T) {
+	const content = "package main\n\nfunc main() {\n\tprintln(\"hi\")\n}\n"
+	tests := []struct {
+		name  string
+		flags map[string]string
+	}{
+		{"line endings only", map[string]string{}},
+		{"full rewrite", map[string]string{"trim-trailing-whitespace": "true"}},
+		{"preserve style", map[string]string{"preserve-style": "true"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.flags["no-cache"] = "true"
+			tt.flags["hash"] = "true"
+			setFlags(t, tt.flags)
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"main.go": content})
+			path := filepath.Join(dir, "main.go")
+			res := handleFile(path)
+			if res.Err != nil || res.SkippedReason != "" {
+				t.Fatalf("got error %v, skipped %q", res.Err, res.SkippedReason)
+			}
+			if res.Changed || res.HashBefore != "" {
+				t.Errorf("an LF file was reported as changed: %+v", res)
+			}
+			if got := readFile(t, path); got != content {
+				t.Errorf("got %q, want %q", got, content)
+			}
+		})
+	}
+}
+
+func TestExplicitSymlinks(t *testing.T) {
+	tests := []struct {
+		noFollow string
+		want     string
+		skipped  bool
+	}{
+		{"false", "a\nb\n", false},
+		{"true", "a\r\nb\r\n", true},
+	}
+	for _, tt := range tests {
+		t.Run("no-follow="+tt.noFollow, func(t *testing.T) {
+			setFlags(t, map[string]string{
+				"no-follow":   tt.noFollow,
+				"no-cache":    "true",
+				"skip-report": filepath.Join(t.TempDir(), "skipped.txt"),
+			})
+			t.Cleanup(func() { skippedPaths = map[string][]string{} })
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"file.txt": "a\r\nb\r\n", "dir/inner.txt": "a\r\nb\r\n"})
+			link := filepath.Join(dir, "link.txt")
+			dirLink := filepath.Join(dir, "linked")
+			if err := os.Symlink(filepath.Join(dir, "file.txt"), link); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink(filepath.Join(dir, "dir"), dirLink); err != nil {
+				t.Fatal(err)
+			}
+			for _, path := range []string{link, dirLink} {
+				if err := handlePath(path, processFile); err != nil {
+					t.Fatal(err)
+				}
+				if got := slices.Contains(skippedPaths[reasonSymlink], path); got != tt.skipped {
+					t.Errorf("%s listed as a skipped symlink: %v, want %v", path, got, tt.skipped)
+				}
+			}
+			for _, name := range []string{"file.txt", "dir/inner.txt"} {
+				if got := readFile(t, filepath.Join(dir, name)); got != tt.want {
+					t.Errorf("%s: got %q, want %q", name, got, tt.want)
+				}
+			}
+			if info, err := os.Lstat(link); err != nil {
+				t.Fatal(err)
+			} else if info.Mode()&os.ModeSymlink == 0 {
+				t.Error("the symlink was replaced by a regular file")
+			}
+		})
+	}
+}
+
+func TestNormalizedTreeUntouched(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags map[string]string
+	}{
+		{"defaults", map[string]string{}},
+		{"crlf", map[string]string{"eol": "crlf"}},
+		{"preserve style", map[string]string{"preserve-style": "true"}},
+		{"all transforms", map[string]string{
+			"trim-trailing-whitespace": "true",
+			"final-newline":            "ensure",
+			"trim-eof-blank-lines":     "true",
+			"max-blank-lines":          "1",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.flags["no-cache"] = "true"
+			setFlags(t, tt.flags)
+			nl := "\n"
+			if *eol == eolCRLF {
+				nl = "\r\n"
+			}
+			dir := t.TempDir()
+			files := map[string]string{
+				"main.go":        "package main" + nl + nl + "func main() {}" + nl,
+				"docs/a.md":      "# Title" + nl + nl + "Some text." + nl,
+				"docs/notes.txt": "one" + nl + "two" + nl,
+			}
+			writeFiles(t, dir, files)
+			before := map[string]os.FileInfo{}
+			for name := range files {
+				info, err := os.Stat(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				before[name] = info
+			}
+			// a file that does need normalizing, to show the others were processed
+			writeFiles(t, dir, map[string]string{"dirty.txt": "one\rtwo" + nl})
+			if err := processPaths([]string{dir}); err != nil {
+				t.Fatal(err)
+			}
+			if got := readFile(t, filepath.Join(dir, "dirty.txt")); got != "one"+nl+"two"+nl {
+				t.Errorf("dirty.txt wasn't normalized: %q", got)
+			}
+			for name, old := range before {
+				info, err := os.Stat(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !os.SameFile(old, info) {
+					t.Errorf("%s was replaced", name)
+				}
+				if !info.ModTime().Equal(old.ModTime()) {
+					t.Errorf("%s was modified", name)
+				}
+			}
+		})
+	}
+}
+
+func TestEmptyFilePolicy(t *testing.T) {
+	tests := []struct {
+		flags   map[string]string
+		exists  bool
+		content string
+		changed bool
+	}{
+		{map[string]string{"empty-file": "keep"}, true, "", false},
+		{map[string]string{"empty-file": "newline"}, true, "\n", true},
+		{map[string]string{"empty-file": "newline", "eol": "crlf"}, true, "\r\n", true},
+		{map[string]string{"empty-file": "newline", "dry-run": "true"}, true, "", true},
+		{map[string]string{"empty-file": "remove"}, false, "", true},
+		{map[string]string{"empty-file": "remove", "dry-run": "true"}, true, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.flags), func(t *testing.T) {
+			setFlags(t, tt.flags)
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"empty.txt": ""})
+			path := filepath.Join(dir, "empty.txt")
+			res := handleFile(path)
+			if res.Err != nil {
+				t.Fatal(res.Err)
+			}
+			if res.Changed != tt.changed {
+				t.Errorf("Changed is %v, want %v", res.Changed, tt.changed)
+			}
+			if skipped := res.SkippedReason == reasonEmpty; skipped == tt.changed {
+				t.Errorf("SkippedReason is %q", res.SkippedReason)
+			}
+			content, err := os.ReadFile(path)
+			if exists := !errors.Is(err, fs.ErrNotExist); exists != tt.exists {
+				t.Fatalf("the file exists: %v, want %v", exists, tt.exists)
+			}
+			if string(content) != tt.content {
+				t.Errorf("got %q, want %q", content, tt.content)
+			}
+		})
+	}
+}
+
+func TestInvalidEmptyFilePolicy(t *testing.T) {
+	t.Cleanup(func() { *emptyFile = emptyKeep })
+	*emptyFile = "truncate"
+	if err := validateOptions(); err == nil || !strings.Contains(err.Error(), "--empty-file") {
+		t.Errorf("got %v, want an --empty-file error", err)
+	}
+}
+
+func TestReadOnlyDirectoryFallback(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   map[string]string
+		content string
+		want    string
+	}{
+		{"rewrite", map[string]string{}, "a\r\nb\r\n", "a\nb\n"},
+		{"unchanged", map[string]string{}, "a\nb\n", "a\nb\n"},
+		{"dry run", map[string]string{"dry-run": "true"}, "a\r\nb\r\n", "a\r\nb\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.flags["no-cache"] = "true"
+			setFlags(t, tt.flags)
+			tmpDir := t.TempDir()
+			t.Setenv("TMPDIR", tmpDir)
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"file.txt": tt.content})
+			path := filepath.Join(dir, "file.txt")
+			if err := os.Chmod(dir, 0o555); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { os.Chmod(dir, 0o755) })
+			if probe, err := os.Create(filepath.Join(dir, "probe")); err == nil {
+				probe.Close()
+				t.Skip("the directory is still writable, e.g. when running as root")
+			}
+			before, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res := handleFile(path); res.Err != nil {
+				t.Fatal(res.Err)
+			}
+			if got := readFile(t, path); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			after, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !os.SameFile(before, after) {
+				t.Error("the file was replaced rather than overwritten")
+			}
+			if left, _ := os.ReadDir(tmpDir); len(left) > 0 {
+				t.Errorf("the temporary copy was left behind: %v", left)
+			}
+		})
+	}
+}
+
+func TestCopyInPlace(t *testing.T) {
+	tests := []struct {
+		name         string
+		content, tmp string
+	}{
+		{"shorter", "a\r\nb\r\n", "a\nb\n"},
+		{"longer", "a\nb\n", "a\r\nb\r\n"},
+		{"empty", "a\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"file.txt": tt.content, "file.txt.tmp": tt.tmp})
+			path := filepath.Join(dir, "file.txt")
+			before, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := copyInPlace(path+".tmp", path); err != nil {
+				t.Fatal(err)
+			}
+			if got := readFile(t, path); got != tt.tmp {
+				t.Errorf("got %q, want %q", got, tt.tmp)
+			}
+			if after, err := os.Stat(path); err != nil {
+				t.Fatal(err)
+			} else if !os.SameFile(before, after) {
+				t.Error("the file was replaced rather than overwritten")
+			}
+			if _, err := os.Stat(path + ".tmp"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("the temporary copy wasn't removed: %v", err)
+			}
+		})
+	}
+}
+
+// trickleReader returns at most n bytes per Read, like a slow network
+// filesystem.
+type trickleReader struct {
+	r io.Reader
+	n int
+}
+
+func (t trickleReader) Read(p []byte) (int, error) {
+	return t.r.Read(p[:min(len(p), t.n)])
+}
+
+func TestDetectionWithShortReads(t *testing.T) {
+	setFlags(t, map[string]string{"probe-size": "256"})
+	latin1, err := charmap.ISO8859_1.NewEncoder().String(strings.Repeat("Le coeur a ses raisons que la raison ne connaît point. ", 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"ASCII", strings.Repeat("plain old text\n", 200)},
+		{"UTF-8", strings.Repeat("naïve café, 日本語のテキスト\n", 200)},
+		{"Latin-1", latin1},
+		{"binary", strings.Repeat("\x00\x01\x02\x03", 1000)},
+	}
+	for _, tt := range tests {
+		for _, n := range []int{1, 3, 100} {
+			t.Run(fmt.Sprintf("%s/%d bytes per read", tt.name, n), func(t *testing.T) {
+				want, err := detectReader(strings.NewReader(tt.content))
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := detectReader(trickleReader{strings.NewReader(tt.content), n})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.isText != want.isText || got.encoding != want.encoding || got.confidence != want.confidence || got.reason != want.reason {
+					t.Errorf("got %+v, want %+v", got, want)
+				}
+				if got.bytesRead != want.bytesRead {
+					t.Errorf("read %d bytes, want %d", got.bytesRead, want.bytesRead)
+				}
+			})
+		}
+	}
+}
+
+func TestInconclusiveDetection(t *testing.T) {
+	// high-half bytes no single-byte or multibyte prober is confident about,
+	// cut off by a probe much shorter than the file
+	content := strings.Repeat("\x81\x8d\x8f\x90\x9d", 100)
+	setFlags(t, map[string]string{"probe-size": "8", "max-chunks": "2", "no-cache": "true"})
+	d, err := detectReader(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.isText || d.encoding != "" || d.reason != reasonInconclusive {
+		t.Errorf("got %+v, want an inconclusive result", d)
+	}
+	if d.bytesRead != 16 {
+		t.Errorf("read %d bytes, want 16", d.bytesRead)
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"data.txt": content})
+	path := filepath.Join(dir, "data.txt")
+	if res := handleFile(path); res.Err != nil || res.Changed || res.SkippedReason != reasonInconclusive {
+		t.Errorf("got %+v, want it skipped as %s", res, reasonInconclusive)
+	}
+	if got := readFile(t, path); got != content {
+		t.Errorf("the file was modified: %q", got)
+	}
+}
+
+func TestDetectionJustUnderThreshold(t *testing.T) {
+	latin1, err := charmap.ISO8859_1.NewEncoder().String(strings.Repeat("Le coeur a ses raisons que la raison ne connaît point. ", 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setFlags(t, map[string]string{"probe-size": "256", "max-chunks": "2", "fallback-confidence": "0"})
+	d, err := detectReader(strings.NewReader(latin1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.confidence <= 0 {
+		t.Fatalf("got confidence %v, want a guess to compare against", d.confidence)
+	}
+	tests := []struct {
+		name          string
+		minConfidence float64
+		isText        bool
+		reason        string
+	}{
+		// the confidence has to be above --min-confidence, so reaching it
+		// exactly is still under the threshold
+		{"at the threshold", d.confidence, false, reasonInconclusive},
+		{"just above the threshold", d.confidence - 0.01, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t, map[string]string{"min-confidence": fmt.Sprint(tt.minConfidence)})
+			got, err := detectReader(strings.NewReader(latin1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.isText != tt.isText || got.reason != tt.reason {
+				t.Errorf("got isText %v, reason %q, want %v, %q", got.isText, got.reason, tt.isText, tt.reason)
+			}
+			if !tt.isText && got.bytesRead != 512 {
+				t.Errorf("read %d bytes, want all of the 2 chunks", got.bytesRead)
+			}
+		})
+	}
+}
+
+// generateTree creates width directories, each nested depth levels deep with
+// files files per level, under dir and returns the number of files.
+func generateTree(tb testing.TB, dir string, width, depth, files int) (n int) {
+	tb.Helper()
+	for i := range width {
+		path := filepath.Join(dir, fmt.Sprintf("d%d", i))
+		for level := range depth {
+			path = filepath.Join(path, fmt.Sprintf("l%d", level))
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				tb.Fatal(err)
+			}
+			for f := range files {
+				if err := os.WriteFile(filepath.Join(path, fmt.Sprintf("f%d.txt", f)), []byte("x\n"), 0o644); err != nil {
+					tb.Fatal(err)
+				}
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func BenchmarkWalk(b *testing.B) {
+	dir := b.TempDir()
+	want := generateTree(b, dir, 20, 10, 10)
+	walkers := map[string]func(count func()) error{
+		"Walk": func(count func()) error {
+			return filepath.Walk(dir, func(path string, info fs.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					count()
+				}
+				return err
+			})
+		},
+		"WalkDir": func(count func()) error {
+			return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+				if err == nil && !d.IsDir() {
+					count()
+				}
+				return err
+			})
+		},
+		// the walk fix-lines does, with the hidden, ignore and exclude checks
+		"handleDir": func(count func()) error {
+			return handleDir(dir, func(string) error {
+				count()
+				return nil
+			})
+		},
+	}
+	for _, name := range []string{"Walk", "WalkDir", "handleDir"} {
+		b.Run(name, func(b *testing.B) {
+			for range b.N {
+				n := 0
+				if err := walkers[name](func() { n++ }); err != nil {
+					b.Fatal(err)
+				}
+				if n != want {
+					b.Fatalf("walked %d files, want %d", n, want)
+				}
+			}
+		})
+	}
+}
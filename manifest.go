@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/wyattis/z/zflag"
+	"github.com/wyattis/z/zset/zstringset"
+)
+
+var manifest = flag.String("manifest", "", "read the roots to process, each with its own options, from this JSON `file` instead of the command line")
+
+// manifestEntry is a single root in a --manifest file. Options are flag names
+// (without dashes) mapped to the value they take while processing this root,
+// e.g. {"root": "web", "options": {"dry-run": true}}. Relative roots are
+// resolved against the manifest's directory.
+type manifestEntry struct {
+	Root    string         `json:"root"`
+	Options map[string]any `json:"options"`
+}
+
+// globalOnlyFlags can't be changed per root because they pick the mode for the
+// whole run or are applied before any root is processed.
+var globalOnlyFlags = zstringset.New(
+	"manifest", "help", "verbose", "cpuprofile", "memprofile", "staged",
+	"emit", "scan-only", "transaction", "slowest", "version",
+	"print-skipped-binary", "null-data", "watch", "watch-debounce",
+	"detect-details", "format", "out", "no-ignore", "no-ignore-vcs", "no-ignore-dot", "follow-symlinks",
+	"skip-vcs-dirs", "no-default-excludes", "hidden", "report", "clear-cache",
+	"encodings", "files-from", "0", "report-encoding-changes",
+)
+
+func loadManifest(path string) ([]manifestEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []manifestEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%s: manifest has no entries", path)
+	}
+	dir := filepath.Dir(path)
+	for i := range entries {
+		e := &entries[i]
+		if e.Root == "" {
+			return nil, fmt.Errorf("%s: entry %d: missing root", path, i+1)
+		}
+		if !filepath.IsAbs(e.Root) {
+			e.Root = filepath.Join(dir, e.Root)
+		}
+		for name, value := range e.Options {
+			if flag.Lookup(name) == nil {
+				return nil, fmt.Errorf("%s: entry %d: unknown option %q", path, i+1, name)
+			}
+			if globalOnlyFlags.Contains(name) {
+				return nil, fmt.Errorf("%s: entry %d: option %q can't be set per root", path, i+1, name)
+			}
+			switch value.(type) {
+			case string, bool, float64:
+			default:
+				return nil, fmt.Errorf("%s: entry %d: option %q must be a string, number or boolean", path, i+1, name)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// runManifest processes every root listed in the manifest at path, applying
+// each entry's options on top of the command line flags for the duration of
+// that root.
+func runManifest(path string) error {
+	entries, err := loadManifest(path)
+	if err != nil {
+		return err
+	}
+	// check every entry's options before touching any files
+	for i, e := range entries {
+		restore, err := applyOptions(e.Options)
+		if err == nil {
+			err = validateOptions()
+		}
+		restore()
+		if err != nil {
+			return fmt.Errorf("%s: entry %d: %w", path, i+1, err)
+		}
+	}
+	for _, e := range entries {
+		restore, err := applyOptions(e.Options)
+		if err == nil {
+			err = validateOptions()
+		}
+		if err != nil {
+			restore()
+			return err
+		}
+		log.Debug("processing manifest root", "root", e.Root, "options", e.Options)
+		paths, err := expandPatterns([]string{e.Root})
+		if err == nil {
+			err = processPaths(paths)
+		}
+		restore()
+		if err != nil {
+			return err
+		}
+	}
+	return validateOptions()
+}
+
+// applyOptions sets each option's flag and returns a function that puts the
+// previous values back.
+func applyOptions(options map[string]any) (restore func(), err error) {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	previous := map[string]string{}
+	// Set appends to the repeatable flags, and their String can't be set
+	// back, so they're restored from a copy instead
+	savedSlices := map[*zflag.StringSliceVar]zflag.StringSliceVar{}
+	restore = func() {
+		for name, value := range previous {
+			flag.Set(name, value)
+		}
+		for slice, value := range savedSlices {
+			*slice = value
+		}
+	}
+	for _, name := range names {
+		if slice, ok := flag.Lookup(name).Value.(*zflag.StringSliceVar); ok {
+			savedSlices[slice] = *slice
+		} else {
+			previous[name] = flag.Lookup(name).Value.String()
+		}
+		if err := flag.Set(name, optionString(options[name])); err != nil {
+			return restore, fmt.Errorf("option %q: %w", name, err)
+		}
+	}
+	return restore, nil
+}
+
+// optionString formats a manifest option value for flag.Set. JSON numbers
+// decode as float64, which fmt would print as e.g. 1e+06 that integer and size
+// flags don't accept.
+func optionString(value any) string {
+	if f, ok := value.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
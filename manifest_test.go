@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeManifest writes content to a manifest file and returns its path.
+func writeManifest(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestManifestNumericOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+		check   func() bool
+	}{
+		{"size", `{"max-size": 1000000}`, func() bool { return maxSize == 1000000 }},
+		{"large size", `{"max-size": 10000000000}`, func() bool { return maxSize == 10000000000 }},
+		{"int", `{"probe-size": 2000000}`, func() bool { return *probeSize == 2000000 }},
+		{"float", `{"min-confidence": 0.5}`, func() bool { return *minConfidence == 0.5 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := loadManifest(writeManifest(t, `[{"root": ".", "options": `+tt.options+`}]`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			restore, err := applyOptions(entries[0].Options)
+			defer restore()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check() {
+				t.Errorf("%s wasn't applied", tt.options)
+			}
+		})
+	}
+}
+
+func TestManifestRejectsGlobalOptions(t *testing.T) {
+	for _, option := range []string{`"format": "sarif"`, `"watch": true`, `"hidden": true`} {
+		t.Run(option, func(t *testing.T) {
+			_, err := loadManifest(writeManifest(t, `[{"root": "a"}, {"root": "b", "options": {`+option+`}}]`))
+			if err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+func TestManifestPerRootExcludes(t *testing.T) {
+	setFlags(t, map[string]string{"no-cache": "true", "exclude": "*.log"})
+	dir := t.TempDir()
+	files := map[string]string{
+		"web/app.js":          "x\r\n",
+		"web/app.min.js":      "x\r\n",
+		"web/gen/types.js":    "x\r\n",
+		"web/debug.log":       "x\r\n",
+		"api/app.min.js":      "x\r\n",
+		"api/gen/types.go":    "x\r\n",
+		"api/fixtures/a.json": "x\r\n",
+		"api/debug.log":       "x\r\n",
+	}
+	writeFiles(t, dir, files)
+	path := filepath.Join(dir, "manifest.json")
+	if err := os.WriteFile(path, []byte(`[
+		{"root": "web", "options": {"exclude": "*.min.js", "exclude-dir": "gen"}},
+		{"root": "api", "options": {"exclude": "fixtures/**,*.go"}}
+	]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runManifest(path); err != nil {
+		t.Fatal(err)
+	}
+	normalized := map[string]bool{"web/app.js": true, "api/app.min.js": true}
+	for name, content := range files {
+		want := content
+		if normalized[name] {
+			want = "x\n"
+		}
+		if got := readFile(t, filepath.Join(dir, filepath.FromSlash(name))); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+	// the command line values are back once the manifest is done
+	if got := excludePatterns.Val(); len(got) != 1 || got[0] != "*.log" {
+		t.Errorf("--exclude is %q after the manifest, want [*.log]", got)
+	}
+	if excludeDirs.Len() != 0 {
+		t.Errorf("--exclude-dir is %q after the manifest", excludeDirs.Val())
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/wyattis/z/zset/zstringset"
+)
+
+// The cheap checks handleFile runs, in this order, before falling back to
+// chardet. Each one can be turned off on its own.
+var skipBinaryExtensions = flag.Bool("skip-binary-extensions", true, "skip files with well known binary extensions (images, archives, executables...) without reading them")
+var maxSize sizeValue
+
+func init() {
+	flag.Var(&maxSize, "max-size", "skip files larger than this `size` (e.g. 500KB, 10MB, 1GiB). 0 disables the limit")
+}
+
+var sniffNUL = flag.Bool("sniff-nul", true, "treat files with a NUL byte in the first probe-size bytes as binary without running encoding detection. Files starting with a UTF-16 or UTF-32 BOM are always passed on to detection")
+
+// Skip reasons for the pre-filters.
+const (
+	reasonBinaryExtension = "binary-extension"
+	reasonTooLarge        = "too-large"
+)
+
+var binaryExtensions = zstringset.New(
+	".7z", ".a", ".avi", ".bin", ".bmp", ".bz2", ".class", ".dll", ".dylib",
+	".eot", ".exe", ".flac", ".gif", ".gz", ".ico", ".jar", ".jpeg", ".jpg",
+	".lib", ".mkv", ".mov", ".mp3", ".mp4", ".o", ".obj", ".ogg", ".otf",
+	".pdf", ".png", ".pyc", ".rar", ".so", ".tar", ".tgz", ".tif", ".tiff",
+	".ttf", ".wasm", ".wav", ".webm", ".webp", ".woff", ".woff2", ".xz",
+	".zip", ".zst",
+)
+
+// detectorRuns counts the files that got past the pre-filters and the
+// detection cache to full encoding detection.
+var detectorRuns int
+
+// utf16And32BOMs are the byte order marks that legitimately put NUL bytes at
+// the start of a text file.
+var utf16And32BOMs = [][]byte{
+	[]byte("\xFF\xFE"), []byte("\xFE\xFF"), []byte("\x00\x00\xFE\xFF"),
+}
+
+// prefilter runs the cheap rejections on path and returns the reason it
+// should be skipped, or "" if it needs full detection.
+func prefilter(path string, info os.FileInfo) (reason string, err error) {
+	if *skipBinaryExtensions && binaryExtensions.Contains(strings.ToLower(filepath.Ext(path))) {
+		return reasonBinaryExtension, nil
+	}
+	if maxSize > 0 && info.Size() > int64(maxSize) {
+		return reasonTooLarge, nil
+	}
+	// files with a known encoding are sniffed by assumedDetection or
+	// forcedDetection, which know about UTF-16
+	_, assumed := assumedEncoding(path)
+	if *sniffNUL && !assumed && *forcedEncoding == "" {
+		binary, err := sniffBinary(path)
+		if err != nil {
+			return "", err
+		}
+		if binary {
+			return reasonBinary, nil
+		}
+	}
+	return "", nil
+}
+
+// sniffBinary reports whether the first probe-size bytes of path contain a
+// NUL byte, ignoring files that start with a UTF-16 or UTF-32 BOM.
+func sniffBinary(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	buf := make([]byte, *probeSize)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	buf = buf[:n]
+	for _, bom := range utf16And32BOMs {
+		if bytes.HasPrefix(buf, bom) {
+			return false, nil
+		}
+	}
+	return bytes.IndexByte(buf, 0) >= 0, nil
+}
+
+// sizeValue is a flag.Value for byte sizes with an optional unit suffix.
+type sizeValue int64
+
+var sizeUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	{"B", 1},
+}
+
+func (s *sizeValue) Set(raw string) error {
+	value := strings.TrimSpace(raw)
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(strings.ToUpper(value), strings.ToUpper(unit.suffix)) && len(value) > len(unit.suffix) {
+			multiplier = unit.size
+			value = strings.TrimSpace(value[:len(value)-len(unit.suffix)])
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", raw)
+	}
+	*s = sizeValue(n * multiplier)
+	return nil
+}
+
+func (s *sizeValue) String() string {
+	return strconv.FormatInt(int64(*s), 10)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrefilterSkipsDetection(t *testing.T) {
+	files := map[string]string{
+		"a.txt":     "a\r\n",
+		"b.go":      "package b\r\n",
+		"c.md":      "# c\r\n",
+		"image.png": "\x89PNG\r\n",
+		"large.txt": strings.Repeat("large\r\n", 100),
+		"data.dat":  "\x01\x00\x02\r\n",
+	}
+	on := map[string]string{"skip-binary-extensions": "true", "max-size": "100", "sniff-nul": "true"}
+	tests := []struct {
+		name string
+		off  map[string]string
+		runs int
+	}{
+		{"all layers", nil, 3},
+		{"no binary extensions", map[string]string{"skip-binary-extensions": "false"}, 4},
+		{"no size cap", map[string]string{"max-size": "0"}, 4},
+		{"no NUL sniff", map[string]string{"sniff-nul": "false"}, 4},
+		{"no layers", map[string]string{"skip-binary-extensions": "false", "max-size": "0", "sniff-nul": "false"}, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := map[string]string{"dry-run": "true", "no-cache": "true"}
+			for _, layers := range []map[string]string{on, tt.off} {
+				for name, value := range layers {
+					flags[name] = value
+				}
+			}
+			setFlags(t, flags)
+			dir := t.TempDir()
+			writeFiles(t, dir, files)
+			detectorRuns = 0
+			t.Cleanup(func() { detectorRuns = 0 })
+			if err := processPaths([]string{dir}); err != nil {
+				t.Fatal(err)
+			}
+			if detectorRuns != tt.runs {
+				t.Errorf("ran detection on %d of %d files, want %d", detectorRuns, len(files), tt.runs)
+			}
+		})
+	}
+}
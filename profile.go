@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+var cpuProfile = flag.String("cpuprofile", "", "write a CPU profile to `file` (inspect with go tool pprof)")
+var memProfile = flag.String("memprofile", "", "write a heap profile to `file` when the run finishes (inspect with go tool pprof)")
+
+// startProfiling starts any profiles requested on the command line. The
+// returned stop function must be called before exiting, including on error,
+// so the profiles are flushed.
+func startProfiling() (stop func(), err error) {
+	var cpuFile *os.File
+	if *cpuProfile != "" {
+		if cpuFile, err = os.Create(*cpuProfile); err != nil {
+			return nil, err
+		}
+		if err = pprof.StartCPUProfile(cpuFile); err != nil {
+			cpuFile.Close()
+			return nil, err
+		}
+	}
+	return func() {
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			if err := cpuFile.Close(); err != nil {
+				log.Error("failed to write cpu profile", "error", err)
+			}
+		}
+		if *memProfile != "" {
+			if err := writeHeapProfile(*memProfile); err != nil {
+				log.Error("failed to write memory profile", "error", err)
+			}
+		}
+	}, nil
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		return err
+	}
+	return f.Close()
+}
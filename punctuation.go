@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"unicode/utf8"
+)
+
+var asciiPunctuation = flag.Bool("ascii-punctuation", false, "also replace curly quotes, dashes and ellipses in UTF-8 files with their ASCII equivalents")
+
+// asciiEquivalents maps the typographic characters --ascii-punctuation
+// replaces to what they're replaced with.
+var asciiEquivalents = map[rune]string{
+	'‘': "'",   // left single quotation mark
+	'’': "'",   // right single quotation mark
+	'‚': "'",   // single low-9 quotation mark
+	'‛': "'",   // single high-reversed-9 quotation mark
+	'“': `"`,   // left double quotation mark
+	'”': `"`,   // right double quotation mark
+	'„': `"`,   // double low-9 quotation mark
+	'‟': `"`,   // double high-reversed-9 quotation mark
+	'–': "-",   // en dash
+	'—': "--",  // em dash
+	'…': "...", // horizontal ellipsis
+}
+
+func hasASCIIEquivalent(r rune) bool {
+	_, ok := asciiEquivalents[r]
+	return ok
+}
+
+// withASCIIPunctuation returns line with its typographic characters replaced
+// and how many were replaced.
+func withASCIIPunctuation(line []byte) ([]byte, int) {
+	if !bytes.ContainsFunc(line, hasASCIIEquivalent) {
+		return line, 0
+	}
+	out := make([]byte, 0, len(line))
+	replaced := 0
+	for i := 0; i < len(line); {
+		r, size := utf8.DecodeRune(line[i:])
+		if ascii, ok := asciiEquivalents[r]; ok {
+			out = append(out, ascii...)
+			replaced++
+		} else {
+			out = append(out, line[i:i+size]...)
+		}
+		i += size
+	}
+	return out, replaced
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+var report = flag.Bool("report", false, "don't modify anything, print which line endings every text file uses (LF, CRLF, CR, mixed or none) and how many of each")
+
+// Line ending styles in the --report output.
+const (
+	styleNone  = "none"
+	styleMixed = "mixed"
+)
+
+// style classifies c as the single kind of line ending used, styleMixed or
+// styleNone.
+func (c eolCounts) style() string {
+	switch {
+	case c.mixed():
+		return styleMixed
+	case c.crlf > 0:
+		return "CRLF"
+	case c.lf > 0:
+		return "LF"
+	case c.cr > 0:
+		return "CR"
+	default:
+		return styleNone
+	}
+}
+
+// runReport prints the line ending style and counts of every text file under
+// paths, followed by how many files use each style.
+func runReport(paths []string) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tSTYLE\tLF\tCRLF\tCR")
+	styles := map[string]int{}
+	for _, path := range paths {
+		if err := handlePath(path, func(path string) error {
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			var c eolCounts
+			// detection calls empty files binary, but they're just empty
+			if info.Size() > 0 {
+				d, err := detectFile(path)
+				if err != nil {
+					return err
+				}
+				if !d.isText {
+					recordSkip(path, d.reason)
+					return nil
+				}
+				if c, err = countFileEOLs(path, d.encoding); err != nil {
+					return err
+				}
+			}
+			styles[c.style()]++
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\n", path, c.style(), c.lf, c.crlf, c.cr)
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	return printStyleSummary(os.Stdout, styles)
+}
+
+func printStyleSummary(w io.Writer, styles map[string]int) error {
+	names := make([]string, 0, len(styles))
+	for style := range styles {
+		names = append(names, style)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STYLE\tFILES")
+	for _, style := range names {
+		fmt.Fprintf(tw, "%s\t%d\n", style, styles[style])
+	}
+	return tw.Flush()
+}
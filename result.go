@@ -0,0 +1,75 @@
+package main
+
+import "fmt"
+
+// FileResult is the outcome of processing a single file.
+type FileResult struct {
+	Path     string
+	Encoding string
+	// Changed is true when the file was (or, in dry-run mode, would have
+	// been) modified.
+	Changed bool
+	// Stats describes the file's lines, if it was read by the rewrite.
+	Stats LineStats
+	// EncodingChanged is true when the file was (or would have been)
+	// re-encoded, from Stats.ConvertedFrom to UTF-8, rather than only having
+	// its lines normalized.
+	EncodingChanged bool
+	// Signature summarizes the line ending change, see LineStats.signature.
+	Signature string
+	// HashBefore and HashAfter are the hex SHA-256 sums of a changed file's
+	// content, when --hash is set.
+	HashBefore string
+	HashAfter  string
+	// SkippedReason is set when the file wasn't processed, e.g. because it
+	// isn't text.
+	SkippedReason string
+	Err           error
+}
+
+// Reasons a file was skipped, in addition to the detection reasons.
+const (
+	reasonUnsupportedEncoding = "unsupported-encoding"
+	reasonEmpty               = "empty"
+	// reasonNotMixed is for files --only-mixed leaves alone.
+	reasonNotMixed = "not-mixed"
+	// reasonInvalidContent is for UTF-16 and UTF-32 files with code units
+	// that can't be decoded, like a lone surrogate or a truncated last unit.
+	reasonInvalidContent = "invalid-content"
+)
+
+func (r FileResult) skipped(reason string) FileResult {
+	r.SkippedReason = reason
+	return r
+}
+
+// invalidContent is the result for a file that turned out not to be valid in
+// its detected encoding. It's skipped with a warning rather than failing the
+// run, since the problem is limited to that one file.
+func (r FileResult) invalidContent() FileResult {
+	log.Warn("skipping file that isn't valid in its detected encoding", "path", r.Path, "encoding", r.Encoding)
+	r.Stats = LineStats{}
+	return r.skipped(reasonInvalidContent)
+}
+
+// fail records err on the result, prefixed with the file's path.
+func (r FileResult) fail(err error) FileResult {
+	r.Err = fmt.Errorf("%s: %w", r.Path, err)
+	return r
+}
+
+// processFile is the walk callback for regular runs. It handles a single file
+// and returns its error, if any.
+func processFile(path string) error {
+	res := handleFile(path)
+	if res.SkippedReason != "" {
+		recordSkip(path, res.SkippedReason)
+	}
+	if res.Err == nil && *format == formatSarif {
+		recordSarif(res)
+	}
+	if res.Err == nil && *reportEncodingChanges != "" {
+		recordEncodingChange(res)
+	}
+	return res.Err
+}
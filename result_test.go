@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileResult(t *testing.T) {
+	setFlags(t, map[string]string{"no-cache": "true", "assume": ".txt=utf-8"})
+	tests := []struct {
+		name    string
+		content string
+		want    FileResult
+	}{
+		{"changed", "a\r\nb\r\n", FileResult{
+			Encoding:  "utf-8",
+			Changed:   true,
+			Stats:     LineStats{Lines: 2, CRLF: 2, Target: "LF"},
+			Signature: "CRLF→LF (2 lines)",
+		}},
+		{"unchanged", "a\nb\n", FileResult{Encoding: "utf-8", Signature: "no change"}},
+		{"binary", "a\x00b", FileResult{SkippedReason: reasonBinary}},
+		{"empty", "", FileResult{SkippedReason: reasonEmpty}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"file.txt": tt.content})
+			path := filepath.Join(dir, "file.txt")
+			got := handleFile(path)
+			if got.Err != nil {
+				t.Fatal(got.Err)
+			}
+			if got.Path != path {
+				t.Errorf("Path is %q, want %q", got.Path, path)
+			}
+			if got.Encoding != tt.want.Encoding || got.Changed != tt.want.Changed || got.SkippedReason != tt.want.SkippedReason {
+				t.Errorf("got encoding %q, changed %v and skipped %q, want %q, %v and %q",
+					got.Encoding, got.Changed, got.SkippedReason, tt.want.Encoding, tt.want.Changed, tt.want.SkippedReason)
+			}
+			if got.Stats.Lines != tt.want.Stats.Lines || got.Stats.CRLF != tt.want.Stats.CRLF || got.Stats.LF != tt.want.Stats.LF {
+				t.Errorf("got stats %+v, want %+v", got.Stats, tt.want.Stats)
+			}
+			if got.Signature != tt.want.Signature {
+				t.Errorf("Signature is %q, want %q", got.Signature, tt.want.Signature)
+			}
+		})
+	}
+}
+
+func TestFileErrorsIncludePath(t *testing.T) {
+	setFlags(t, map[string]string{"no-cache": "true"})
+	dir := t.TempDir()
+	tests := []struct {
+		name   string
+		path   string
+		target error
+	}{
+		{"missing file", filepath.Join(dir, "missing.txt"), fs.ErrNotExist},
+		{"missing directory", filepath.Join(dir, "missing", "file.txt"), fs.ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := handleFile(tt.path)
+			if res.Err == nil {
+				t.Fatal("no error")
+			}
+			if !strings.HasPrefix(res.Err.Error(), tt.path+": ") {
+				t.Errorf("the error doesn't start with the path: %v", res.Err)
+			}
+			if !errors.Is(res.Err, tt.target) {
+				t.Errorf("the error doesn't wrap %v: %v", tt.target, res.Err)
+			}
+			if err := processFile(tt.path); err == nil || err.Error() != res.Err.Error() {
+				t.Errorf("processFile returned %v, want %v", err, res.Err)
+			}
+		})
+	}
+}
+
+func TestRewriteErrorIncludesPath(t *testing.T) {
+	setFlags(t, map[string]string{"no-cache": "true", "assume": ".txt=utf-8"})
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"file.txt": "a\r\n"})
+	path := filepath.Join(dir, "file.txt")
+	// a directory in the way of the temporary copy makes the rewrite fail
+	// after detection, even when running as root
+	if err := os.Mkdir(path+".tmp", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	res := handleFile(path)
+	if res.Err == nil {
+		t.Fatal("no error")
+	}
+	if !strings.HasPrefix(res.Err.Error(), path+": ") {
+		t.Errorf("the error doesn't start with the path: %v", res.Err)
+	}
+}
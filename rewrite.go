@@ -0,0 +1,674 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var stripInnerBOM = flag.Bool("strip-inner-bom", false, "remove UTF-8 BOMs found after the start of a file, e.g. where two files were concatenated")
+var reportInnerBOM = flag.Bool("report-inner-bom", false, "report the byte offsets of UTF-8 BOMs found after the start of a file")
+
+var eol = flag.String("eol", eolLF, "line ending to normalize to: lf, crlf, cr or native (crlf on Windows, lf elsewhere)")
+
+var preserveStyle = flag.Bool("preserve-style", false, "normalize stray line endings to whichever line ending each file mostly uses instead of --eol. Needs an extra pass over every file. Files without a single most common one get --eol")
+
+var maxBlankLines = flag.Int("max-blank-lines", -1, "collapse runs of more than `N` consecutive empty lines down to N. -1 disables the limit")
+var trimEOFBlankLines = flag.Bool("trim-eof-blank-lines", false, "remove empty lines at the end of each file")
+var crAsContent = flag.Bool("cr-as-content", false, "only treat LF and CRLF as line endings and keep bare CRs as part of the line, e.g. for logs with progress bars that redraw themselves with CR")
+var unicodeNFC = flag.Bool("unicode-nfc", false, "also normalize the content of UTF-8 files to Unicode NFC, e.g. to stop decomposed text from macOS causing diff noise")
+var onlyMixed = flag.Bool("only-mixed", false, "only rewrite files that use more than one kind of line ending, leaving consistently CRLF or LF files alone. Needs an extra pass over every file")
+var finalNewline = flag.String("final-newline", finalNewlineKeep, "what to do with the line ending of the last line: keep (leave a missing one missing), ensure (add one if it's missing) or strip (remove it)")
+var trimTrailingWhitespace = flag.Bool("trim-trailing-whitespace", false, "also strip spaces and tabs from the end of every line")
+
+var utf8BOM = []byte("\xEF\xBB\xBF")
+
+// --eol values
+const (
+	eolLF   = "lf"
+	eolCRLF = "crlf"
+	eolCR   = "cr"
+	// eolNative picks CRLF on Windows and LF everywhere else.
+	eolNative = "native"
+)
+
+// --final-newline policies
+const (
+	finalNewlineEnsure = "ensure"
+	finalNewlineStrip  = "strip"
+	finalNewlineKeep   = "keep"
+)
+
+// eolTerminators maps each --eol value to the terminator it writes.
+var eolTerminators = map[string]string{
+	eolLF:   "\n",
+	eolCRLF: "\r\n",
+	eolCR:   "\r",
+}
+
+func init() {
+	if runtime.GOOS == "windows" {
+		eolTerminators[eolNative] = eolTerminators[eolCRLF]
+	} else {
+		eolTerminators[eolNative] = eolTerminators[eolLF]
+	}
+}
+
+// lineRewriter normalizes the line endings of a stream of lines.
+type lineRewriter struct {
+	// include selects which lines, by 1-based number, are normalized. Other
+	// lines are copied through verbatim, terminator included. A nil include
+	// matches every line.
+	include func(line int) bool
+	// findInnerBOMs records the byte offset of every UTF-8 BOM that isn't at
+	// the very start of the input in stats.InnerBOMs.
+	findInnerBOMs bool
+	// stripInnerBOMs removes inner BOMs from normalized lines.
+	stripInnerBOMs bool
+	// stripLeadingBOM removes a UTF-8 BOM from the start of the first line.
+	stripLeadingBOM bool
+	// addLeadingBOM writes a UTF-8 BOM before the first line if it doesn't
+	// start with one.
+	addLeadingBOM bool
+	// heredocs, if set, tracks heredoc bodies so CRLF inside them can be
+	// reported.
+	heredocs *heredocTracker
+	// eol is the terminator written after every normalized line. Empty means
+	// LF.
+	eol string
+	// trimTrailingWhitespace strips spaces and tabs from the end of
+	// normalized lines.
+	trimTrailingWhitespace bool
+	// nfc normalizes lines to Unicode NFC. Only set for UTF-8 content.
+	nfc bool
+	// stripInvisibles removes zero-width characters. Only set for UTF-8
+	// content.
+	stripInvisibles bool
+	// asciiPunctuation replaces typographic quotes, dashes and ellipses.
+	// Only set for UTF-8 content.
+	asciiPunctuation bool
+	// indent converts leading indentation to indentTabs or indentSpaces,
+	// tabWidth columns per tab. Empty leaves indentation alone.
+	indent   string
+	tabWidth int
+	// maxBlankLines is the longest run of empty normalized lines kept, or
+	// negative for no limit.
+	maxBlankLines int
+	// crIsContent splits lines on LF only, so a bare CR is part of the line
+	// rather than a line ending.
+	crIsContent bool
+	// trimEOFBlankLines drops the empty lines at the end of the input.
+	trimEOFBlankLines bool
+	// finalNewline is the --final-newline policy for the last line. Empty
+	// means keep.
+	finalNewline string
+	// convertFrom is the encoding the content is converted to UTF-8 from,
+	// if --convert-to-utf8 applies. See encodedRewrite.
+	convertFrom string
+
+	stats LineStats
+}
+
+// LineStats describes the lines a lineRewriter saw in its input.
+type LineStats struct {
+	Lines int
+	CRLF  int
+	LF    int
+	// CR counts the lines ending in a bare CR.
+	CR int
+	// Unterminated is true when the last line had no line ending.
+	Unterminated bool
+	// InnerBOMs are the byte offsets of UTF-8 BOMs found after the start of
+	// the input, if they were looked for.
+	InnerBOMs []int64
+	// Target is the line ending lines were normalized to, e.g. "CRLF".
+	Target string
+	// TrimmedLines counts the lines trailing whitespace was removed from.
+	TrimmedLines int
+	// NFCLines counts the lines changed by NFC normalization.
+	NFCLines int
+	// InvisiblesRemoved counts the zero-width characters removed.
+	InvisiblesRemoved int
+	// PunctuationReplaced counts the typographic characters replaced.
+	PunctuationReplaced int
+	// ReindentedLines counts the lines whose indentation was converted.
+	ReindentedLines int
+	// RemovedBlankLines counts the empty lines dropped by maxBlankLines and
+	// trimEOFBlankLines.
+	RemovedBlankLines int
+	// BOM is "added" or "removed" when the rewrite changed the leading UTF-8
+	// BOM.
+	BOM string
+	// FinalNewline is "added" or "removed" when the rewrite changed whether
+	// the last line ends with a line ending.
+	FinalNewline string
+	// ConvertedFrom is the original encoding of content that was converted
+	// to UTF-8.
+	ConvertedFrom string
+}
+
+// newLineRewriter returns a lineRewriter writing the --eol terminator.
+func newLineRewriter() *lineRewriter {
+	return &lineRewriter{
+		eol:                    eolTerminators[*eol],
+		trimTrailingWhitespace: *trimTrailingWhitespace,
+		finalNewline:           *finalNewline,
+		indent:                 *indent,
+		tabWidth:               *tabWidth,
+		maxBlankLines:          *maxBlankLines,
+		trimEOFBlankLines:      *trimEOFBlankLines,
+		crIsContent:            *crAsContent,
+	}
+}
+
+// setEncoding turns on the options that only apply to content in encoding,
+// like the UTF-8 BOM handling.
+func (rw *lineRewriter) setEncoding(encoding string) {
+	upper := strings.ToUpper(encoding)
+	if *convertToUTF8 && canConvert(upper) {
+		// from here on the content is treated as the UTF-8 it will be
+		rw.convertFrom = encoding
+		upper = "UTF-8"
+	}
+	if utf8Encodings.Contains(upper) {
+		rw.stripLeadingBOM = *stripBOM || *bomMode == bomStrip
+	}
+	// decoded content is UTF-8 too, but keeps whatever BOM it had
+	if utf8Encodings.Contains(upper) || decodableEncodings.Contains(upper) {
+		rw.findInnerBOMs = *stripInnerBOM || *reportInnerBOM
+		rw.stripInnerBOMs = *stripInnerBOM
+		rw.nfc = *unicodeNFC
+		rw.stripInvisibles = *stripInvisibles
+		rw.asciiPunctuation = *asciiPunctuation
+	}
+	if utf8Encodings.Contains(upper) || upper == "ASCII" {
+		rw.addLeadingBOM = *bomMode == bomAdd
+	}
+}
+
+// setPath applies the options that depend on the file at path: its
+// --eol-rules line ending, and turning off what would break it, like
+// indenting a Makefile with spaces.
+func (rw *lineRewriter) setPath(path string) {
+	rw.eol = eolFor(path)
+	if rw.indent == indentSpaces && isMakefile(path) {
+		rw.indent = ""
+	}
+}
+
+// eolCounts are the number of line endings of each kind in some input.
+type eolCounts struct {
+	crlf, lf, cr int
+	// last is the input's last byte.
+	last byte
+}
+
+// countEOLs counts the line endings in input. Bare CRs aren't counted with
+// --cr-as-content.
+func countEOLs(input io.Reader) (c eolCounts, err error) {
+	var prev byte
+	chunk := make([]byte, 32*1024)
+	for {
+		n, err := input.Read(chunk)
+		for _, b := range chunk[:n] {
+			switch {
+			case b == '\n' && prev == '\r':
+				c.crlf++
+			case b == '\n':
+				c.lf++
+			case prev == '\r':
+				c.cr++
+			}
+			prev = b
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return c, err
+		}
+	}
+	if prev == '\r' {
+		c.cr++
+	}
+	c.last = prev
+	if *crAsContent {
+		c.cr = 0
+	}
+	return c, nil
+}
+
+// countFileEOLs is countEOLs for the file at path, whose content is in
+// encoding.
+func countFileEOLs(path, encoding string) (eolCounts, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return eolCounts{}, err
+	}
+	defer file.Close()
+	decoded, _ := decodingReader(encoding, file)
+	return countEOLs(decoded)
+}
+
+// dominant returns the most common terminator, or fallback when there's no
+// single most common one.
+func (c eolCounts) dominant(fallback string) string {
+	switch {
+	case c.crlf > c.lf && c.crlf > c.cr:
+		return "\r\n"
+	case c.lf > c.crlf && c.lf > c.cr:
+		return "\n"
+	case c.cr > c.crlf && c.cr > c.lf:
+		return "\r"
+	default:
+		return fallback
+	}
+}
+
+// mixed reports whether more than one kind of line ending was found.
+func (c eolCounts) mixed() bool {
+	kinds := 0
+	for _, n := range []int{c.crlf, c.lf, c.cr} {
+		if n > 0 {
+			kinds++
+		}
+	}
+	return kinds > 1
+}
+
+// onlyLineEndings reports whether rw changes nothing but line endings, so
+// whether it changes a file can be told from the file's eolCounts.
+func (rw *lineRewriter) onlyLineEndings() bool {
+	return rw.convertFrom == "" && !rw.stripInnerBOMs && !rw.stripLeadingBOM && !rw.addLeadingBOM &&
+		!rw.nfc && !rw.stripInvisibles && !rw.asciiPunctuation && rw.indent == "" &&
+		!rw.trimTrailingWhitespace && rw.maxBlankLines < 0 && !rw.trimEOFBlankLines
+}
+
+// wouldChange reports whether rewriting a non-empty input with the line
+// endings in c could change it. It errs on the side of true.
+func (rw *lineRewriter) wouldChange(c eolCounts) bool {
+	if !rw.onlyLineEndings() {
+		return true
+	}
+	var others int
+	switch rw.eol {
+	case "\r\n":
+		others = c.lf + c.cr
+	case "\r":
+		others = c.crlf + c.lf
+	default:
+		others = c.crlf + c.cr
+	}
+	if others > 0 {
+		return true
+	}
+	// a bare CR at the very end is stripped like a line ending even when CRs
+	// are otherwise content
+	if rw.crIsContent && c.last == '\r' {
+		return true
+	}
+	terminated := c.last == '\n' || c.last == '\r'
+	switch rw.finalNewline {
+	case finalNewlineEnsure:
+		return !terminated
+	case finalNewlineStrip:
+		return terminated
+	default:
+		return false
+	}
+}
+
+// dominantEOL returns the terminator input mostly uses, see
+// eolCounts.dominant.
+func dominantEOL(input io.Reader, fallback string) (string, error) {
+	c, err := countEOLs(input)
+	return c.dominant(fallback), err
+}
+
+// dominantFileEOL is dominantEOL for the file at path.
+func dominantFileEOL(path, encoding, fallback string) (string, error) {
+	c, err := countFileEOLs(path, encoding)
+	return c.dominant(fallback), err
+}
+
+// rewrite copies input to output line by line, replacing each line's
+// terminator with rw.eol. Every input line produces exactly one output line, so
+// blank lines, including any run of them at the end of the file, are kept as
+// they are and only their terminators change. The last line's terminator is
+// added, removed or kept according to rw.finalNewline.
+func (rw *lineRewriter) rewrite(input io.Reader, output io.Writer) error {
+	terminator := rw.eol
+	if terminator == "" {
+		terminator = "\n"
+	}
+	rw.stats = LineStats{Target: eolName(terminator), ConvertedFrom: rw.convertFrom}
+	buf := bufio.NewReader(input)
+	outBuf := bufio.NewWriter(output)
+	scanner := bufio.NewScanner(buf)
+	// grow the buffer as far as a line needs instead of failing at the
+	// default 64KB token limit, e.g. on minified JS or JSON
+	scanner.Buffer(make([]byte, 0, 64*1024), math.MaxInt)
+	split := scanLinesWithAnyEOL
+	if rw.crIsContent {
+		split = scanLinesWithEOL
+	}
+	scanner.Split((&resumingSplit{split: split}).scan)
+	var offset int64
+	// pending is the terminator owed to the previous normalized line. It's
+	// held back until the next line shows up so --final-newline can decide
+	// what happens to the last one.
+	pending := ""
+	terminated := false
+	// blankRun counts the consecutive empty lines seen so far.
+	blankRun := 0
+	// held counts the empty lines not written yet because they may turn out
+	// to be at the end of the file.
+	held := 0
+	flushHeld := func() {
+		for ; held > 0; held-- {
+			outBuf.WriteString(pending)
+			pending = terminator
+			terminated = true
+		}
+	}
+	for n := 1; scanner.Scan(); n++ {
+		if scanner.Err() != nil {
+			return scanner.Err()
+		}
+		raw := scanner.Bytes()
+		rw.count(raw)
+		if rw.findInnerBOMs {
+			rw.recordInnerBOMs(raw, offset)
+		}
+		offset += int64(len(raw))
+		if rw.include != nil && !rw.include(n) {
+			flushHeld()
+			outBuf.WriteString(pending)
+			pending = ""
+			blankRun = 0
+			outBuf.Write(raw)
+			continue
+		}
+		if rw.heredocs != nil {
+			rw.heredocs.observe(n, raw)
+		}
+		line := trimEOL(raw)
+		// decided before the transforms below, which can shorten the line
+		hasEOL := len(line) < len(raw)
+		if rw.crIsContent && terminator == "\n" {
+			// a CR kept right before the LF would read back as a CRLF
+			line = bytes.TrimRight(line, "\r")
+		}
+		if n == 1 && rw.stripLeadingBOM && bytes.HasPrefix(line, utf8BOM) {
+			line = line[len(utf8BOM):]
+			rw.stats.BOM = "removed"
+		}
+		if n == 1 && rw.addLeadingBOM && !bytes.HasPrefix(line, utf8BOM) {
+			outBuf.Write(utf8BOM)
+			rw.stats.BOM = "added"
+		}
+		if rw.stripInnerBOMs {
+			line = withoutInnerBOMs(line, n == 1)
+		}
+		if rw.stripInvisibles {
+			var removed int
+			line, removed = withoutInvisibles(line, n == 1)
+			rw.stats.InvisiblesRemoved += removed
+		}
+		if rw.asciiPunctuation {
+			var replaced int
+			line, replaced = withASCIIPunctuation(line)
+			rw.stats.PunctuationReplaced += replaced
+		}
+		if rw.nfc && !norm.NFC.IsNormal(line) {
+			line = norm.NFC.Bytes(line)
+			rw.stats.NFCLines++
+		}
+		if rw.indent != "" {
+			if reindented := reindent(line, rw.indent, rw.tabWidth); !bytes.Equal(reindented, line) {
+				rw.stats.ReindentedLines++
+				line = reindented
+			}
+		}
+		if rw.trimTrailingWhitespace {
+			if trimmed := bytes.TrimRight(line, " \t"); len(trimmed) < len(line) {
+				rw.stats.TrimmedLines++
+				line = trimmed
+			}
+		}
+		if len(line) == 0 {
+			blankRun++
+		} else {
+			blankRun = 0
+		}
+		if rw.maxBlankLines >= 0 && blankRun > rw.maxBlankLines {
+			rw.stats.RemovedBlankLines++
+			continue
+		}
+		if rw.trimEOFBlankLines {
+			if len(line) == 0 {
+				held++
+				continue
+			}
+			flushHeld()
+		}
+		outBuf.WriteString(pending)
+		terminated = hasEOL
+		log.Debug("replacing line", "line", string(line))
+		outBuf.Write(line)
+		pending = terminator
+	}
+	if scanner.Err() != nil {
+		return scanner.Err()
+	}
+	rw.stats.RemovedBlankLines += held
+	if pending != "" {
+		switch rw.finalNewline {
+		case finalNewlineStrip:
+			if terminated {
+				rw.stats.FinalNewline = "removed"
+			}
+			pending = ""
+		case finalNewlineEnsure:
+			if !terminated {
+				rw.stats.FinalNewline = "added"
+			}
+		default:
+			if !terminated {
+				pending = ""
+			}
+		}
+		outBuf.WriteString(pending)
+	}
+	return outBuf.Flush()
+}
+
+// signature summarizes the line endings before and after the rewrite, e.g.
+// "CRLF→LF (312 lines)" or "CRLF+LF→LF (20 lines)" for a mixed file.
+func (s LineStats) signature(changed bool) string {
+	if !changed {
+		return "no change"
+	}
+	var sources []string
+	if s.CRLF > 0 {
+		sources = append(sources, "CRLF")
+	}
+	if s.LF > 0 {
+		sources = append(sources, "LF")
+	}
+	if s.CR > 0 {
+		sources = append(sources, "CR")
+	}
+	if len(sources) == 0 {
+		sources = append(sources, "none")
+	}
+	target := s.Target
+	if target == "" {
+		target = "LF"
+	}
+	sig := fmt.Sprintf("%s→%s (%d lines", strings.Join(sources, "+"), target, s.Lines)
+	if s.ConvertedFrom != "" {
+		sig += ", converted from " + s.ConvertedFrom + " to UTF-8"
+	}
+	if s.FinalNewline != "" {
+		sig += ", final newline " + s.FinalNewline
+	}
+	if s.BOM != "" {
+		sig += ", BOM " + s.BOM
+	}
+	if s.InvisiblesRemoved > 0 {
+		sig += fmt.Sprintf(", %d invisible characters removed", s.InvisiblesRemoved)
+	}
+	if s.PunctuationReplaced > 0 {
+		sig += fmt.Sprintf(", %d typographic characters replaced", s.PunctuationReplaced)
+	}
+	if s.NFCLines > 0 {
+		sig += fmt.Sprintf(", NFC normalized on %d", s.NFCLines)
+	}
+	if s.ReindentedLines > 0 {
+		sig += fmt.Sprintf(", indentation converted on %d", s.ReindentedLines)
+	}
+	if s.RemovedBlankLines > 0 {
+		sig += fmt.Sprintf(", %d blank lines removed", s.RemovedBlankLines)
+	}
+	if s.TrimmedLines > 0 {
+		sig += fmt.Sprintf(", trailing whitespace trimmed on %d", s.TrimmedLines)
+	}
+	return sig + ")"
+}
+
+// eolName returns the conventional name of a line terminator, e.g. "CRLF".
+func eolName(terminator string) string {
+	switch terminator {
+	case "\r\n":
+		return "CRLF"
+	case "\r":
+		return "CR"
+	default:
+		return "LF"
+	}
+}
+
+func (rw *lineRewriter) count(line []byte) {
+	rw.stats.Lines++
+	switch {
+	case bytes.HasSuffix(line, []byte("\r\n")):
+		rw.stats.CRLF++
+	case bytes.HasSuffix(line, []byte("\n")):
+		rw.stats.LF++
+	case !rw.crIsContent && bytes.HasSuffix(line, []byte("\r")):
+		rw.stats.CR++
+	default:
+		rw.stats.Unterminated = true
+	}
+}
+
+func (rw *lineRewriter) recordInnerBOMs(line []byte, offset int64) {
+	for i := 0; ; {
+		j := bytes.Index(line[i:], utf8BOM)
+		if j < 0 {
+			return
+		}
+		if at := offset + int64(i+j); at > 0 {
+			rw.stats.InnerBOMs = append(rw.stats.InnerBOMs, at)
+		}
+		i += j + len(utf8BOM)
+	}
+}
+
+// withoutInnerBOMs removes every UTF-8 BOM from line, except for one at the
+// start of the first line.
+func withoutInnerBOMs(line []byte, first bool) []byte {
+	keep := 0
+	if first && bytes.HasPrefix(line, utf8BOM) {
+		keep = len(utf8BOM)
+	}
+	if bytes.Index(line[keep:], utf8BOM) < 0 {
+		return line
+	}
+	return append(line[:keep:keep], bytes.ReplaceAll(line[keep:], utf8BOM, nil)...)
+}
+
+// scanLinesWithEOL is like bufio.ScanLines but keeps the line terminator as
+// part of the token.
+func scanLinesWithEOL(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, data[:i+1], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// scanLinesWithAnyEOL is like scanLinesWithEOL but also ends a line at a bare
+// CR, the classic Mac line ending. A CR at the end of data waits for more
+// input in case it's the start of a CRLF.
+func scanLinesWithAnyEOL(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i+1], nil
+		}
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i+2], nil
+			}
+			return i + 1, data[:i+1], nil
+		}
+		if atEOF {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// resumingSplit runs split only over the part of an unfinished line it
+// hasn't searched yet. bufio.Scanner passes the whole line again after every
+// read, so a long line arriving in small reads, like decoded UTF-16, took time
+// quadratic in its length. split has to return tokens that are a prefix of
+// its input.
+type resumingSplit struct {
+	split bufio.SplitFunc
+	// scanned is how much of the current line was searched before.
+	scanned int
+}
+
+func (s *resumingSplit) scan(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	// back up a byte, a CR at the end may turn out to start a CRLF
+	from := min(max(s.scanned-1, 0), len(data))
+	advance, token, err = s.split(data[from:], atEOF)
+	if advance == 0 && token == nil && err == nil {
+		s.scanned = len(data)
+		return 0, nil, nil
+	}
+	s.scanned = 0
+	if token != nil {
+		token = data[:from+len(token)]
+	}
+	return from + advance, token, err
+}
+
+// trimEOL strips a trailing LF and then a trailing CR from line, the same way
+// bufio.ScanLines does.
+func trimEOL(line []byte) []byte {
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	return bytes.TrimSuffix(line, []byte("\r"))
+}
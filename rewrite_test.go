@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"golang.org/x/text/encoding/unicode"
+)
+
+// rewriteString runs rw over input and returns the output.
+func rewriteString(t *testing.T, rw *lineRewriter, input string) string {
+	t.Helper()
+	var out bytes.Buffer
+	if err := rw.rewrite(strings.NewReader(input), &out); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestFinalNewline(t *testing.T) {
+	tests := []struct {
+		name         string
+		rw           lineRewriter
+		input        string
+		want         string
+		finalNewline string
+	}{
+		{"keep unterminated", lineRewriter{}, "foo", "foo", ""},
+		{"keep terminated", lineRewriter{}, "foo\r\n", "foo\n", ""},
+		{"keep with trimmed whitespace", lineRewriter{trimTrailingWhitespace: true}, "foo   ", "foo", ""},
+		{"keep with stripped BOM", lineRewriter{stripLeadingBOM: true}, "\xef\xbb\xbfhello", "hello", ""},
+		{"ensure", lineRewriter{finalNewline: finalNewlineEnsure}, "foo", "foo\n", "added"},
+		{"ensure terminated", lineRewriter{finalNewline: finalNewlineEnsure}, "foo\n", "foo\n", ""},
+		{"strip", lineRewriter{finalNewline: finalNewlineStrip}, "foo\r\n", "foo", "removed"},
+		{"strip unterminated", lineRewriter{finalNewline: finalNewlineStrip}, "foo", "foo", ""},
+		{"strip unterminated with trimmed whitespace", lineRewriter{finalNewline: finalNewlineStrip, trimTrailingWhitespace: true}, "foo \t", "foo", ""},
+		// --eol=lf keeps three trailing blank lines as they are, only their
+		// line endings change, whatever the final newline policy does
+		{"keep trailing blank lines", lineRewriter{eol: "\n"}, "foo\r\n\r\n\r\n\r\n", "foo\n\n\n\n", ""},
+		{"keep unterminated trailing blank lines", lineRewriter{eol: "\n"}, "foo\r\n\r\n\r\n", "foo\n\n\n", ""},
+		{"ensure trailing blank lines", lineRewriter{eol: "\n", finalNewline: finalNewlineEnsure}, "foo\r\n\r\n\r\n\r\n", "foo\n\n\n\n", ""},
+		{"ensure unterminated trailing blank lines", lineRewriter{eol: "\n", finalNewline: finalNewlineEnsure}, "foo\r\n\r\n\r\n  ", "foo\n\n\n  \n", "added"},
+		{"strip trailing blank lines", lineRewriter{eol: "\n", finalNewline: finalNewlineStrip}, "foo\r\n\r\n\r\n\r\n", "foo\n\n\n", "removed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := tt.rw
+			rw.maxBlankLines = -1
+			if got := rewriteString(t, &rw, tt.input); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if rw.stats.FinalNewline != tt.finalNewline {
+				t.Errorf("FinalNewline is %q, want %q", rw.stats.FinalNewline, tt.finalNewline)
+			}
+		})
+	}
+}
+
+func TestScanLinesWithAnyEOL(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		lines []string
+	}{
+		{"LF", "a\nb\n", []string{"a\n", "b\n"}},
+		{"CRLF", "a\r\nb", []string{"a\r\n", "b"}},
+		{"bare CR", "a\rb\r", []string{"a\r", "b\r"}},
+		{"mixed", "a\rb\r\nc\nd", []string{"a\r", "b\r\n", "c\n", "d"}},
+		{"CR before CRLF", "a\r\r\n", []string{"a\r", "\r\n"}},
+	}
+	splits := map[string]func() bufio.SplitFunc{
+		"plain":    func() bufio.SplitFunc { return scanLinesWithAnyEOL },
+		"resuming": func() bufio.SplitFunc { return (&resumingSplit{split: scanLinesWithAnyEOL}).scan },
+	}
+	for _, tt := range tests {
+		for name, split := range splits {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				// feed one byte at a time, so a CRLF is always split across
+				// reads
+				scanner := bufio.NewScanner(iotest.OneByteReader(strings.NewReader(tt.input)))
+				scanner.Split(split())
+				var lines []string
+				for scanner.Scan() {
+					lines = append(lines, scanner.Text())
+				}
+				if !slices.Equal(lines, tt.lines) {
+					t.Errorf("got %q, want %q", lines, tt.lines)
+				}
+			})
+		}
+	}
+}
+
+func TestBareCRLineEndings(t *testing.T) {
+	tests := []struct {
+		name        string
+		rw          lineRewriter
+		input, want string
+	}{
+		{"to LF", lineRewriter{eol: "\n"}, "a\rb\rc\r", "a\nb\nc\n"},
+		{"to CRLF", lineRewriter{eol: "\r\n"}, "a\rb\nc", "a\r\nb\r\nc"},
+		{"to CR", lineRewriter{eol: "\r"}, "a\r\nb\n", "a\rb\r"},
+		{"CR as content", lineRewriter{eol: "\n", crIsContent: true}, "10%\r50%\r100%\r\n", "10%\r50%\r100%\n"},
+		{"CR as content before CRLF", lineRewriter{eol: "\n", crIsContent: true}, "50%\r\r\n100%\r\r\r\n", "50%\n100%\n"},
+		{"CR as content before CRLF to CRLF", lineRewriter{eol: "\r\n", crIsContent: true}, "50%\r\r\n", "50%\r\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := tt.rw
+			rw.maxBlankLines = -1
+			if got := rewriteString(t, &rw, tt.input); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreserveStyleCountsBareCR(t *testing.T) {
+	tests := []struct {
+		name, input string
+		crAsContent string
+		want        string
+	}{
+		{"mostly CR", "a\rb\rc\n", "false", "\r"},
+		{"mostly CRLF", "a\r\nb\r\nc\r", "false", "\r\n"},
+		{"tie", "a\rb\n", "false", "fallback"},
+		{"CR as content", "a\rb\rc\n", "true", "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t, map[string]string{"cr-as-content": tt.crAsContent})
+			c, err := countEOLs(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.dominant("fallback"); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnlyStrayLinesChange(t *testing.T) {
+	rw := lineRewriter{eol: "\n", maxBlankLines: -1}
+	if got, want := rewriteString(t, &rw, "a\nb\r\nc\rd\n"), "a\nb\nc\nd\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if rw.stats.LF != 2 || rw.stats.CRLF != 1 || rw.stats.CR != 1 {
+		t.Errorf("got %d LF, %d CRLF and %d CR lines, want 2, 1 and 1", rw.stats.LF, rw.stats.CRLF, rw.stats.CR)
+	}
+}
+
+// progressLog returns a log of lines lines, each redrawn frames times with a
+// bare CR like a terminal progress bar, ending in eol.
+func progressLog(lines, frames int, eol string) string {
+	var b strings.Builder
+	for i := range lines {
+		for j := range frames {
+			fmt.Fprintf(&b, "step %d: %d%%\r", i, j*100/frames)
+		}
+		fmt.Fprintf(&b, "step %d: done%s", i, eol)
+	}
+	return b.String()
+}
+
+func TestCRAsContentProgressLog(t *testing.T) {
+	const lines, frames = 3, 1000
+	tests := []struct {
+		name      string
+		flags     map[string]string
+		input     string
+		want      string
+		lineCount int
+	}{
+		{"LF log unchanged", map[string]string{"cr-as-content": "true"},
+			progressLog(lines, frames, "\n"), progressLog(lines, frames, "\n"), lines},
+		{"CRLF log to LF", map[string]string{"cr-as-content": "true"},
+			progressLog(lines, frames, "\r\n"), progressLog(lines, frames, "\n"), lines},
+		{"LF log to CRLF", map[string]string{"cr-as-content": "true", "eol": "crlf"},
+			progressLog(lines, frames, "\n"), progressLog(lines, frames, "\r\n"), lines},
+		{"CRs as line endings", map[string]string{},
+			progressLog(lines, frames, "\n"), strings.ReplaceAll(progressLog(lines, frames, "\n"), "\r", "\n"), lines * (frames + 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.flags["assume"] = ".log=utf-8"
+			setFlags(t, tt.flags)
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"build.log": tt.input})
+			path := filepath.Join(dir, "build.log")
+			res := handleFile(path)
+			if res.Err != nil {
+				t.Fatal(res.Err)
+			}
+			if got := readFile(t, path); got != tt.want {
+				t.Errorf("got %q..., want %q...", got[:min(len(got), 80)], tt.want[:min(len(tt.want), 80)])
+			}
+			if res.Changed != (tt.input != tt.want) {
+				t.Errorf("Changed is %v", res.Changed)
+			}
+			if res.Changed && res.Stats.Lines != tt.lineCount {
+				t.Errorf("got %d lines, want %d", res.Stats.Lines, tt.lineCount)
+			}
+		})
+	}
+}
+
+func TestMultiMegabyteLines(t *testing.T) {
+	long := strings.Repeat(`{"key":"value","n":12345},`, 200_000) // about 5MB
+	tests := []struct {
+		name    string
+		flags   map[string]string
+		file    string
+		content string
+		want    string
+	}{
+		{"single unterminated line", map[string]string{}, "min.js", long, long},
+		{"CRLF", map[string]string{}, "min.js", long + "\r\n" + long + "\r\n", long + "\n" + long + "\n"},
+		{"to CRLF", map[string]string{"eol": "crlf"}, "min.js", long + "\n", long + "\r\n"},
+		{"bare CR", map[string]string{}, "min.js", long + "\r" + long, long + "\n" + long},
+		{"preserve style", map[string]string{"preserve-style": "true"}, "min.js", long + "\r\n" + long + "\r\nx\n", long + "\r\n" + long + "\r\nx\r\n"},
+		{"only mixed", map[string]string{"only-mixed": "true"}, "min.js", long + "\r\n" + long + "\n", long + "\n" + long + "\n"},
+		{"trimmed", map[string]string{"trim-trailing-whitespace": "true", "final-newline": "ensure"}, "min.js", long + "  \t", long + "\n"},
+		{"UTF-16", map[string]string{}, "data.json", utf16Encoder(unicode.LittleEndian)(long + "\r\n"), utf16Encoder(unicode.LittleEndian)(long + "\n")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.flags["assume"] = ".js=utf-8"
+			tt.flags["no-cache"] = "true"
+			setFlags(t, tt.flags)
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{tt.file: tt.content})
+			path := filepath.Join(dir, tt.file)
+			res := handleFile(path)
+			if res.Err != nil || res.SkippedReason != "" {
+				t.Fatalf("got error %v, skipped %q", res.Err, res.SkippedReason)
+			}
+			if got := readFile(t, path); got != tt.want {
+				t.Errorf("got %d bytes ending in %q, want %d bytes ending in %q", len(got), got[max(0, len(got)-8):], len(tt.want), tt.want[len(tt.want)-8:])
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+var format = flag.String("format", formatText, "output format: text, or sarif to print a SARIF report of the files that need normalizing to stdout without modifying anything")
+
+// --format values
+const (
+	formatText  = "text"
+	formatSarif = "sarif"
+)
+
+// SARIF rule IDs for the kinds of problems fix-lines fixes.
+const (
+	ruleCRLF                = "crlf-line-ending"
+	ruleMissingFinalNewline = "missing-final-newline"
+	ruleInnerBOM            = "inner-bom"
+	ruleEmptyFile           = "empty-file"
+	ruleTrailingWhitespace  = "trailing-whitespace"
+	ruleNeedsNormalization  = "needs-normalization"
+)
+
+var sarifRules = []sarifRule{
+	{ID: ruleCRLF, ShortDescription: sarifMessage{"Line ends with CRLF instead of LF"}},
+	{ID: ruleMissingFinalNewline, ShortDescription: sarifMessage{"File doesn't end with a newline"}},
+	{ID: ruleInnerBOM, ShortDescription: sarifMessage{"UTF-8 BOM found after the start of the file"}},
+	{ID: ruleEmptyFile, ShortDescription: sarifMessage{"Empty file doesn't match the --empty-file policy"}},
+	{ID: ruleTrailingWhitespace, ShortDescription: sarifMessage{"Line ends with spaces or tabs"}},
+	{ID: ruleNeedsNormalization, ShortDescription: sarifMessage{"File content would be changed by fix-lines"}},
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+var sarifResults = []sarifResult{}
+
+// recordSarif adds a finding for each kind of problem in res, if the file
+// would be changed.
+func recordSarif(res FileResult) {
+	if !res.Changed {
+		return
+	}
+	add := func(rule, message string) {
+		sarifResults = append(sarifResults, sarifResult{
+			RuleID:  rule,
+			Level:   "warning",
+			Message: sarifMessage{message},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: filepath.ToSlash(res.Path)},
+				},
+			}},
+		})
+	}
+	found := false
+	if res.Stats.CRLF > 0 && res.Stats.Target == "LF" {
+		add(ruleCRLF, fmt.Sprintf("%d of %d lines end with CRLF", res.Stats.CRLF, res.Stats.Lines))
+		found = true
+	}
+	if res.Stats.FinalNewline == "added" {
+		add(ruleMissingFinalNewline, "the last line has no line ending")
+		found = true
+	}
+	if len(res.Stats.InnerBOMs) > 0 {
+		add(ruleInnerBOM, fmt.Sprintf("BOMs at byte offsets %v", res.Stats.InnerBOMs))
+		found = true
+	}
+	if res.Stats.TrimmedLines > 0 {
+		add(ruleTrailingWhitespace, fmt.Sprintf("%d of %d lines have trailing whitespace", res.Stats.TrimmedLines, res.Stats.Lines))
+		found = true
+	}
+	if res.Stats.Lines == 0 {
+		add(ruleEmptyFile, fmt.Sprintf("empty file would be handled with --empty-file=%s", *emptyFile))
+		found = true
+	}
+	if !found {
+		add(ruleNeedsNormalization, "file would be normalized")
+	}
+}
+
+func writeSarif(w io.Writer) error {
+	report := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "fix-lines",
+				InformationURI: "https://github.com/wyattis/fix-lines",
+				Rules:          sarifRules,
+			}},
+			Results: sarifResults,
+		}},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// confidenceBuckets are the upper bounds (inclusive) used to bucket detection
+// confidences in the --scan-only report.
+var confidenceBuckets = []float64{0.5, 0.8, 0.9, 0.95, 0.99, 1}
+
+type scanStats struct {
+	files int
+	text  int
+	// inconclusive counts files that hit --max-chunks without a decision.
+	inconclusive int
+	bytes        int64
+	elapsed      time.Duration
+	buckets      []int
+}
+
+// runScan runs detection over every file under paths without rewriting
+// anything and prints a throughput and confidence report. It's meant for
+// tuning --probe-size against a real tree.
+func runScan(paths []string) error {
+	stats := scanStats{buckets: make([]int, len(confidenceBuckets))}
+	start := time.Now()
+	for _, path := range paths {
+		if err := handlePath(path, func(path string) error {
+			d, err := detectFile(path)
+			if err != nil {
+				return err
+			}
+			stats.add(d)
+			if *detectDetails != "" {
+				return writeDetectionDetails(os.Stdout, path, d)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	stats.elapsed = time.Since(start)
+	if *detectDetails == detailsJSON {
+		// keep stdout valid JSON lines
+		return stats.print(os.Stderr)
+	}
+	return stats.print(os.Stdout)
+}
+
+func (s *scanStats) add(d detection) {
+	s.files++
+	s.bytes += d.bytesRead
+	if d.isText {
+		s.text++
+	}
+	if d.reason == reasonInconclusive {
+		s.inconclusive++
+	}
+	for i, bound := range confidenceBuckets {
+		if d.confidence <= bound {
+			s.buckets[i]++
+			break
+		}
+	}
+}
+
+func (s *scanStats) print(w io.Writer) error {
+	seconds := s.elapsed.Seconds()
+	if seconds == 0 {
+		seconds = 1e-9
+	}
+	fmt.Fprintf(w, "scanned %d files (%d text, %d inconclusive) in %s\n", s.files, s.text, s.inconclusive, s.elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "probed %.2f MB, %.1f files/sec, %.2f MB/sec\n", float64(s.bytes)/1e6, float64(s.files)/seconds, float64(s.bytes)/1e6/seconds)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONFIDENCE\tFILES")
+	lower := 0.0
+	for i, bound := range confidenceBuckets {
+		fmt.Fprintf(tw, "%.2f-%.2f\t%d\n", lower, bound, s.buckets[i])
+		lower = bound
+	}
+	return tw.Flush()
+}
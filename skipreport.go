@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/wyattis/z/zset/zstringset"
+)
+
+var skipReport = flag.String("skip-report", "", "write the paths of every skipped file, grouped by the reason it was skipped, to this `file`")
+var printSkippedBinary = flag.Bool("print-skipped-binary", false, "print the path of every file skipped as binary to stdout, one per line, e.g. to pipe them to another tool")
+var nullData = flag.Bool("null-data", false, "separate the paths printed by --print-skipped-binary with NUL instead of newline")
+
+// Skip reasons for paths left out of the walk. Skipped directories are
+// recorded rather than every file below them.
+const (
+	reasonSymlink = "symlink"
+	// reasonExcluded is for --exclude, --exclude-dir, VCS and the default
+	// excluded directories.
+	reasonExcluded = "excluded"
+	// reasonIgnored is for paths excluded by ignore files.
+	reasonIgnored = "ignored"
+	reasonHidden  = "hidden"
+)
+
+// skippedPaths maps each skip reason to the paths skipped for it.
+var skippedPaths = map[string][]string{}
+
+// binarySkipReasons are the skip reasons --print-skipped-binary reports: the
+// files detection didn't find to be text, whether it probed all of them or
+// gave up at --max-chunks.
+var binarySkipReasons = zstringset.New(reasonBinary, reasonInconclusive, reasonBinaryExtension)
+
+func recordSkip(path, reason string) {
+	if *printSkippedBinary && binarySkipReasons.Contains(reason) {
+		sep := "\n"
+		if *nullData {
+			sep = "\x00"
+		}
+		fmt.Fprint(os.Stdout, path+sep)
+	}
+	if *skipReport == "" {
+		return
+	}
+	skippedPaths[reason] = append(skippedPaths[reason], path)
+}
+
+// writeSkipReport writes one section per skip reason, listing the affected
+// paths, to path.
+func writeSkipReport(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reasons := make([]string, 0, len(skippedPaths))
+	for reason := range skippedPaths {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	w := bufio.NewWriter(file)
+	for _, reason := range reasons {
+		paths := skippedPaths[reason]
+		fmt.Fprintf(w, "%s (%d)\n", reason, len(paths))
+		for _, p := range paths {
+			fmt.Fprintf(w, "  %s\n", p)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSkipReportReasons(t *testing.T) {
+	dir := t.TempDir()
+	report := filepath.Join(t.TempDir(), "skipped.txt")
+	setFlags(t, map[string]string{"skip-report": report, "exclude": "*.min.js", "dry-run": "true", "no-cache": "true"})
+	t.Cleanup(func() { skippedPaths = map[string][]string{} })
+	writeFiles(t, dir, map[string]string{
+		".gitignore":        "build/\n",
+		"main.go":           "package main\r\n",
+		"app.min.js":        "x\r\n",
+		".env":              "A=1\r\n",
+		"build/out.txt":     "x\r\n",
+		"node_modules/a.js": "x\r\n",
+		"image.png":         "\x89PNG",
+		"data.dat":          "\x00\x01\x02",
+	})
+	if err := os.Symlink("main.go", filepath.Join(dir, "link.go")); err != nil {
+		t.Fatal(err)
+	}
+	if err := processPaths([]string{dir}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeSkipReport(report); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		reason string
+		path   string
+	}{
+		{reasonHidden, ".gitignore"},
+		{reasonHidden, ".env"},
+		{reasonIgnored, "build"},
+		{reasonExcluded, "app.min.js"},
+		{reasonExcluded, "node_modules"},
+		{reasonSymlink, "link.go"},
+		{reasonBinaryExtension, "image.png"},
+		{reasonBinary, "data.dat"},
+	}
+	content := readFile(t, report)
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.path)
+		if !slices.Contains(skippedPaths[tt.reason], path) {
+			t.Errorf("%s isn't listed as %s, got %v", tt.path, tt.reason, skippedPaths)
+		}
+		if !strings.Contains(content, tt.reason+" (") || !strings.Contains(content, "  "+path+"\n") {
+			t.Errorf("the report has no %s entry for %s:\n%s", tt.reason, tt.path, content)
+		}
+	}
+}
+
+// captureStdout returns what f writes to os.Stdout.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	f()
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestPrintSkippedBinary(t *testing.T) {
+	tests := []struct {
+		reason  string
+		printed bool
+	}{
+		{reasonBinary, true},
+		{reasonInconclusive, true},
+		{reasonBinaryExtension, true},
+		{reasonSymlink, false},
+		{reasonTooLarge, false},
+		{reasonUnsupportedEncoding, false},
+	}
+	for _, null := range []string{"false", "true"} {
+		for _, tt := range tests {
+			t.Run(tt.reason+"/null-data="+null, func(t *testing.T) {
+				setFlags(t, map[string]string{"print-skipped-binary": "true", "null-data": null})
+				want := ""
+				if tt.printed {
+					want = "some/file\n"
+					if null == "true" {
+						want = "some/file\x00"
+					}
+				}
+				if got := captureStdout(t, func() { recordSkip("some/file", tt.reason) }); got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+			})
+		}
+	}
+}
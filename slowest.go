@@ -0,0 +1,61 @@
+package main
+
+import (
+	"container/heap"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+var slowest = flag.Int("slowest", 0, "print the `N` slowest files at the end of the run")
+
+type fileTiming struct {
+	path     string
+	duration time.Duration
+}
+
+// slowestHeap is a min-heap on duration so the fastest of the tracked files
+// is always the one evicted.
+type slowestHeap []fileTiming
+
+func (h slowestHeap) Len() int           { return len(h) }
+func (h slowestHeap) Less(i, j int) bool { return h[i].duration < h[j].duration }
+func (h slowestHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *slowestHeap) Push(x any)        { *h = append(*h, x.(fileTiming)) }
+func (h *slowestHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+var slowestFiles slowestHeap
+
+// recordSlowest keeps track of the --slowest files seen so far.
+func recordSlowest(path string, duration time.Duration) {
+	if len(slowestFiles) < *slowest {
+		heap.Push(&slowestFiles, fileTiming{path, duration})
+		return
+	}
+	if duration > slowestFiles[0].duration {
+		slowestFiles[0] = fileTiming{path, duration}
+		heap.Fix(&slowestFiles, 0)
+	}
+}
+
+func printSlowest(w io.Writer) error {
+	files := append([]fileTiming(nil), slowestFiles...)
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].duration > files[j].duration
+	})
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DURATION\tPATH")
+	for _, f := range files {
+		fmt.Fprintf(tw, "%s\t%s\n", f.duration.Round(time.Microsecond), f.path)
+	}
+	return tw.Flush()
+}
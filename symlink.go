@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+
+	"github.com/wyattis/z/zset/zstringset"
+)
+
+var followSymlinks = flag.Bool("follow-symlinks", false, "follow symlinks to files and directories found while walking instead of skipping them. Every file and directory is processed once, however many links lead to it, and links back to a directory being walked are skipped")
+
+// dirWalker holds the state of walking one root given on the command line.
+type dirWalker struct {
+	root   string
+	ig     *ignorer
+	handle func(path string) error
+	// visited holds the absolute real paths of the directories and files
+	// processed so far, and realDirs maps the walked directories to their
+	// real path. Both are only kept with --follow-symlinks.
+	visited  *zstringset.Set
+	realDirs map[string]string
+}
+
+func newDirWalker(root string, handle func(path string) error) *dirWalker {
+	dw := &dirWalker{root: root, ig: newIgnorer(root), handle: handle}
+	if *followSymlinks {
+		dw.visited = zstringset.New()
+		dw.realDirs = map[string]string{}
+	}
+	return dw
+}
+
+// realPath returns the absolute path of path with every symlink resolved.
+func realPath(path string) (string, error) {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(real)
+}
+
+// visitDir records that the directory at path, which is found at onDisk, is
+// being walked. It reports false if it was walked before.
+func (dw *dirWalker) visitDir(path, onDisk string) bool {
+	if dw.visited == nil {
+		return true
+	}
+	real, err := realPath(onDisk)
+	if err != nil {
+		return true
+	}
+	if dw.visited.Contains(real) {
+		return false
+	}
+	dw.visited.Add(real)
+	dw.realDirs[path] = real
+	return true
+}
+
+// visitFile records that the file at path is being processed. It reports
+// false if it was processed before.
+func (dw *dirWalker) visitFile(path string) bool {
+	if dw.visited == nil {
+		return true
+	}
+	real := filepath.Join(dw.realDirs[filepath.Dir(path)], filepath.Base(path))
+	if dw.visited.Contains(real) {
+		return false
+	}
+	dw.visited.Add(real)
+	return true
+}
+
+// follow handles the symlink found at path while walking. Links to files are
+// processed through their target, so the target is rewritten rather than the
+// link replaced, and links to directories are walked as if the directory was
+// at path.
+func (dw *dirWalker) follow(path string) error {
+	target, err := realPath(path)
+	if err != nil {
+		log.Warn("skipping broken symlink", "path", path, "error", err)
+		recordSkip(path, reasonSymlink)
+		return nil
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		return err
+	}
+	if dw.visited.Contains(target) {
+		log.Debug("skipping symlink to a path that was already processed", "path", path, "target", target)
+		return nil
+	}
+	if info.IsDir() {
+		if isExcludedDir(dw.root, path) {
+			log.Debug("skipping excluded directory", "path", path)
+			recordSkip(path, reasonExcluded)
+			return nil
+		}
+		return dw.walk(path, target)
+	}
+	if !info.Mode().IsRegular() || !isIncluded(dw.root, path) {
+		return nil
+	}
+	dw.visited.Add(target)
+	log.Debug("following symlink", "path", path, "target", target)
+	return dw.handle(target)
+}
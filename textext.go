@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"path/filepath"
+	"strings"
+
+	"github.com/wyattis/z/zset/zstringset"
+)
+
+var onlyTextExtensions = flag.Bool("only-text-extensions", false, "only process files with a known text extension, treating them as UTF-8 without running encoding detection. Everything else is skipped")
+var trustExtensions = flag.Bool("trust-extensions", false, "treat files with a known text extension as UTF-8 without running encoding detection. Other files are detected as usual")
+var textExtensionsFlag = flag.String("text-extensions", "", "comma separated extensions that replace the built-in --only-text-extensions and --trust-extensions list")
+var addTextExtensions = flag.String("add-text-extensions", "", "comma separated extensions to add to the --only-text-extensions and --trust-extensions list")
+
+// defaultTextExtensions are extensions that are almost always UTF-8 (or
+// ASCII) text.
+var defaultTextExtensions = []string{
+	".c", ".cc", ".cfg", ".cmake", ".conf", ".cpp", ".cs", ".css", ".csv",
+	".dart", ".env", ".go", ".gradle", ".graphql", ".h", ".hpp", ".htm",
+	".html", ".ini", ".java", ".js", ".json", ".jsx", ".kt", ".less", ".lua",
+	".md", ".mjs", ".php", ".pl", ".properties", ".proto", ".py", ".rb",
+	".rs", ".rst", ".sass", ".scala", ".scss", ".sh", ".sql", ".svelte",
+	".swift", ".tf", ".toml", ".ts", ".tsx", ".txt", ".vue", ".xml",
+	".yaml", ".yml",
+}
+
+// reasonNotTextExtension is the skip reason for files excluded by
+// --only-text-extensions.
+const reasonNotTextExtension = "not-text-extension"
+
+var textExtensions = zstringset.New(defaultTextExtensions...)
+
+// parseTextExtensions builds the --only-text-extensions list from the
+// defaults and the --text-extensions/--add-text-extensions flags.
+func parseTextExtensions() {
+	base := defaultTextExtensions
+	if *textExtensionsFlag != "" {
+		base = splitExtensions(*textExtensionsFlag)
+	}
+	textExtensions = zstringset.New(base...)
+	textExtensions.Add(splitExtensions(*addTextExtensions)...)
+}
+
+// splitExtensions splits a comma separated list of extensions, normalizing
+// each to lowercase with a leading dot.
+func splitExtensions(value string) (exts []string) {
+	for _, ext := range strings.Split(value, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts = append(exts, ext)
+	}
+	return
+}
+
+func isTextExtension(path string) bool {
+	return textExtensions.Contains(strings.ToLower(filepath.Ext(path)))
+}
+
+// extensionDetection returns the detection of a file with a known text
+// extension as UTF-8. The extension is only trusted for content that can be
+// UTF-8: a UTF-16 or UTF-32 BOM or a NUL byte in the first probe-size bytes
+// leave the file to detection (ok is false), since rewriting UTF-16 as if it
+// was UTF-8 would corrupt it.
+func extensionDetection(path string) (d detection, ok bool, err error) {
+	head, err := readHead(path, *probeSize)
+	if err != nil {
+		return d, false, err
+	}
+	if bom := bomEncoding(head); (bom != "" && bom != "UTF-8-SIG") || bytes.IndexByte(head, 0) >= 0 {
+		log.Debug("not trusting the extension of content that isn't UTF-8", "path", path)
+		return d, false, nil
+	}
+	return detection{isText: true, encoding: "UTF-8", confidence: 1}, true, nil
+}
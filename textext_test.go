@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// copyTestdata copies the testdata file name to dir and returns the copy's
+// path.
+func copyTestdata(t *testing.T, dir, name string) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExtensionShortcutChecksContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		encoding string
+	}{
+		{"UTF-8", "1_crlf.utf8.txt", "UTF-8"},
+		{"UTF-16LE with BOM", "1_crlf.utf16le.txt", "UTF-16"},
+		{"UTF-16BE with BOM", "1_crlf.utf16be.txt", "UTF-16"},
+	}
+	for _, mode := range []string{"only-text-extensions", "trust-extensions"} {
+		for _, tt := range tests {
+			t.Run(mode+"/"+tt.name, func(t *testing.T) {
+				setFlags(t, map[string]string{mode: "true", "no-cache": "true"})
+				d, err := detectForRewrite(filepath.Join("testdata", tt.file))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !d.isText || !strings.EqualFold(d.encoding, tt.encoding) {
+					t.Errorf("got isText %v, encoding %q, want %q", d.isText, d.encoding, tt.encoding)
+				}
+			})
+		}
+	}
+}
+
+func TestTrustExtensionsRoundTripsUTF16(t *testing.T) {
+	for _, file := range []string{"1_crlf.utf16le.txt", "1_crlf.utf16be.txt"} {
+		t.Run(file, func(t *testing.T) {
+			original, err := os.ReadFile(filepath.Join("testdata", file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			crlf, lf := "\r\x00\n\x00", "\n\x00"
+			if strings.Contains(file, "be") {
+				crlf, lf = "\x00\r\x00\n", "\x00\n"
+			}
+			tests := []struct {
+				eol  string
+				want string
+			}{
+				{"crlf", string(original)},
+				{"lf", strings.ReplaceAll(string(original), crlf, lf)},
+			}
+			for _, tt := range tests {
+				t.Run(tt.eol, func(t *testing.T) {
+					setFlags(t, map[string]string{"trust-extensions": "true", "no-cache": "true", "eol": tt.eol})
+					path := copyTestdata(t, t.TempDir(), file)
+					if res := handleFile(path); res.Err != nil || res.SkippedReason != "" {
+						t.Fatalf("got error %v, skipped %q", res.Err, res.SkippedReason)
+					}
+					if got := readFile(t, path); got != tt.want {
+						t.Errorf("got % x\nwant % x", got, tt.want)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestOnlyTextExtensionsSkipsOthers(t *testing.T) {
+	files := []string{"main.go", "notes.txt", "RUN.SH", "data.xyz", "Makefile", "build.log"}
+	tests := []struct {
+		name      string
+		flags     map[string]string
+		processed []string
+	}{
+		{"built-in list", map[string]string{}, []string{"main.go", "notes.txt", "RUN.SH"}},
+		{"added", map[string]string{"add-text-extensions": "xyz"}, []string{"main.go", "notes.txt", "RUN.SH", "data.xyz"}},
+		{"replaced", map[string]string{"text-extensions": ".xyz,.log"}, []string{"data.xyz", "build.log"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.flags["only-text-extensions"] = "true"
+			tt.flags["no-cache"] = "true"
+			setFlags(t, tt.flags)
+			dir := t.TempDir()
+			for _, file := range files {
+				writeFiles(t, dir, map[string]string{file: "a\r\nb\r\n"})
+			}
+			for _, file := range files {
+				path := filepath.Join(dir, file)
+				res := handleFile(path)
+				if res.Err != nil {
+					t.Fatal(res.Err)
+				}
+				want, wantReason := "a\r\nb\r\n", reasonNotTextExtension
+				if slices.Contains(tt.processed, file) {
+					want, wantReason = "a\nb\n", ""
+				}
+				if res.SkippedReason != wantReason {
+					t.Errorf("%s: skipped %q, want %q", file, res.SkippedReason, wantReason)
+				}
+				if got := readFile(t, path); got != want {
+					t.Errorf("%s: got %q, want %q", file, got, want)
+				}
+			}
+		})
+	}
+}
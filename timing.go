@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// timingStats accumulates how long was spent detecting and rewriting files,
+// broken down by the detected encoding. It is only populated in verbose mode.
+type timingStats struct {
+	detect     time.Duration
+	rewrite    time.Duration
+	byEncoding map[string]*encodingTiming
+}
+
+type encodingTiming struct {
+	files   int
+	detect  time.Duration
+	rewrite time.Duration
+}
+
+var timings = timingStats{byEncoding: map[string]*encodingTiming{}}
+
+func (t *timingStats) record(encoding string, detect, rewrite time.Duration) {
+	if encoding == "" {
+		encoding = "(none)"
+	}
+	encoding = strings.ToUpper(encoding)
+	t.detect += detect
+	t.rewrite += rewrite
+	e, ok := t.byEncoding[encoding]
+	if !ok {
+		e = &encodingTiming{}
+		t.byEncoding[encoding] = e
+	}
+	e.files++
+	e.detect += detect
+	e.rewrite += rewrite
+}
+
+func (t *timingStats) print(w io.Writer) error {
+	encodings := make([]string, 0, len(t.byEncoding))
+	for encoding := range t.byEncoding {
+		encodings = append(encodings, encoding)
+	}
+	sort.Strings(encodings)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENCODING\tFILES\tDETECT\tREWRITE")
+	total := 0
+	for _, encoding := range encodings {
+		e := t.byEncoding[encoding]
+		total += e.files
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", encoding, e.files, e.detect.Round(time.Microsecond), e.rewrite.Round(time.Microsecond))
+	}
+	fmt.Fprintf(tw, "TOTAL\t%d\t%s\t%s\n", total, t.detect.Round(time.Microsecond), t.rewrite.Round(time.Microsecond))
+	return tw.Flush()
+}
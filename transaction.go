@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// pendingRename is a rewritten file waiting to be moved into place by
+// commitPending when running with --transaction.
+type pendingRename struct {
+	tmpPath string
+	path    string
+	// copyBack means tmpPath is outside path's directory and has to be
+	// copied into place.
+	copyBack bool
+	// remove means path is deleted on commit, e.g. by --empty-file=remove.
+	// There's no temporary file.
+	remove bool
+}
+
+var pendingRenames []pendingRename
+
+// stagedPaths holds the absolute path of every file in pendingRenames. A file
+// reached twice, e.g. through a directory and as an explicit argument, is
+// only staged the first time.
+var stagedPaths = map[string]bool{}
+
+// stagedKey returns the key of path in stagedPaths.
+func stagedKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// isStaged reports whether a rewrite of path is already waiting for
+// commitPending.
+func isStaged(path string) bool {
+	return stagedPaths[stagedKey(path)]
+}
+
+// stage queues p for commitPending.
+func stage(p pendingRename) {
+	log.Debug("staging change", "path", p.path, "tmp", p.tmpPath, "remove", p.remove)
+	stagedPaths[stagedKey(p.path)] = true
+	pendingRenames = append(pendingRenames, p)
+}
+
+// commitPending moves every staged temporary file into place. If a rename
+// fails the remaining temporary files are removed, but files already renamed
+// stay modified.
+func commitPending() error {
+	total := len(pendingRenames)
+	log.Debug("committing transaction", "files", total)
+	for i, p := range pendingRenames {
+		replace := replaceFile
+		switch {
+		case p.remove:
+			replace = func(_, path string) error { return os.Remove(path) }
+		case p.copyBack:
+			replace = copyInPlace
+		}
+		if err := replace(p.tmpPath, p.path); err != nil {
+			pendingRenames = pendingRenames[i:]
+			rollbackPending()
+			return fmt.Errorf("transaction partially committed (%d of %d files): %w", i, total, err)
+		}
+	}
+	pendingRenames = nil
+	stagedPaths = map[string]bool{}
+	return nil
+}
+
+// rollbackPending removes every staged temporary file, leaving the originals
+// untouched.
+func rollbackPending() {
+	log.Info("rolling back transaction", "files", len(pendingRenames))
+	for _, p := range pendingRenames {
+		if p.remove {
+			continue
+		}
+		if err := os.Remove(p.tmpPath); err != nil {
+			log.Error("failed to remove temporary file", "path", p.tmpPath, "error", err)
+		}
+	}
+	pendingRenames = nil
+	stagedPaths = map[string]bool{}
+}
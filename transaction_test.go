@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTransactionStagesFileOnce(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+	}{
+		{"directory and file", []string{"d", filepath.Join("d", "a.txt")}},
+		{"file and directory", []string{filepath.Join("d", "a.txt"), "d"}},
+		{"same file twice", []string{filepath.Join("d", "a.txt"), filepath.Join(".", "d", "..", "d", "a.txt")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t, map[string]string{"transaction": "true", "no-cache": "true"})
+			t.Cleanup(rollbackPending)
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"d/a.txt": "a\r\n", "d/b.txt": "b\r\n"})
+			chdir(t, dir)
+			for _, path := range tt.paths {
+				if err := handlePath(path, processFile); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := readFile(t, filepath.Join("d", "a.txt")); got != "a\r\n" {
+				t.Errorf("a.txt was changed before the commit: %q", got)
+			}
+			if err := commitPending(); err != nil {
+				t.Fatal(err)
+			}
+			if got := readFile(t, filepath.Join("d", "a.txt")); got != "a\n" {
+				t.Errorf("got %q, want %q", got, "a\n")
+			}
+			if _, err := os.Stat(filepath.Join("d", "a.txt.tmp")); !os.IsNotExist(err) {
+				t.Errorf("the temporary copy was left behind: %v", err)
+			}
+		})
+	}
+}
+
+func TestTransactionEmptyFiles(t *testing.T) {
+	tests := []struct {
+		emptyFile string
+		exists    bool
+		content   string
+	}{
+		{"newline", true, "\n"},
+		{"remove", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.emptyFile, func(t *testing.T) {
+			setFlags(t, map[string]string{"transaction": "true", "empty-file": tt.emptyFile, "no-cache": "true"})
+			t.Cleanup(rollbackPending)
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"x.txt": "x\r\n", "empty.txt": ""})
+			empty := filepath.Join(dir, "empty.txt")
+			for _, path := range []string{filepath.Join(dir, "x.txt"), empty, empty} {
+				if err := handlePath(path, processFile); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// a failing file rolls back the empty file's change too
+			if err := handlePath(filepath.Join(dir, "missing.txt"), processFile); err == nil {
+				t.Fatal("no error for a missing file")
+			}
+			rollbackPending()
+			if got := readFile(t, empty); got != "" {
+				t.Errorf("the empty file was changed before the commit: %q", got)
+			}
+			if got := readFile(t, filepath.Join(dir, "x.txt")); got != "x\r\n" {
+				t.Errorf("x.txt was changed before the commit: %q", got)
+			}
+			// and a successful run applies it on commit
+			for _, path := range []string{filepath.Join(dir, "x.txt"), empty, empty} {
+				if err := handlePath(path, processFile); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := commitPending(); err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(empty)
+			if exists := !os.IsNotExist(err); exists != tt.exists {
+				t.Fatalf("empty.txt exists: %v, want %v", exists, tt.exists)
+			}
+			if string(content) != tt.content {
+				t.Errorf("got %q, want %q", content, tt.content)
+			}
+			if left, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(left) > 0 {
+				t.Errorf("temporary copies were left behind: %v", left)
+			}
+		})
+	}
+}
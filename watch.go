@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+var watch = flag.Bool("watch", false, "after the initial run, keep watching the given paths and normalize files as they are created or modified, until interrupted")
+var watchDebounce = flag.Duration("watch-debounce", 200*time.Millisecond, "with --watch, how long a path has to stay quiet before it's processed")
+
+// watcher feeds changed files under the watched roots through handleFile.
+type watcher struct {
+	fs *fsnotify.Watcher
+	// files are paths passed directly as roots. Their parent directory is
+	// watched, and only events for these files are acted on within it.
+	files map[string]bool
+	// dirs are the directories watched recursively.
+	dirs map[string]bool
+	// pending maps paths with unprocessed events to when they last changed.
+	pending map[string]time.Time
+	// written maps files fix-lines rewrote to their modification time after
+	// the rewrite, so the events caused by its own rename can be ignored.
+	written map[string]time.Time
+	// roots are the directories passed as roots, which --exclude patterns
+	// are relative to.
+	roots []string
+	// ignorers hold the ignore rules of the watched directories.
+	ignorers []*ignorer
+}
+
+func runWatch(paths []string) error {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer fsw.Close()
+	w := &watcher{
+		fs:      fsw,
+		files:   map[string]bool{},
+		dirs:    map[string]bool{},
+		pending: map[string]time.Time{},
+		written: map[string]time.Time{},
+	}
+	for _, path := range paths {
+		if err := w.add(path); err != nil {
+			return err
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	log.Info("watching for changes", "paths", len(paths))
+	tick := time.NewTicker(*watchDebounce / 2)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-fsw.Events:
+			if !ok {
+				return nil
+			}
+			w.observe(event)
+		case err, ok := <-fsw.Errors:
+			if !ok {
+				return nil
+			}
+			log.Warn("watch error", "error", err)
+		case now := <-tick.C:
+			w.flush(now)
+		}
+	}
+}
+
+// add starts watching path, a root given on the command line.
+func (w *watcher) add(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if isExcludedArg(path, info.IsDir()) {
+		return nil
+	}
+	if !info.IsDir() {
+		w.files[filepath.Clean(path)] = true
+		return w.fs.Add(filepath.Dir(path))
+	}
+	w.roots = append(w.roots, filepath.Clean(path))
+	if ig := newIgnorer(path); ig != nil {
+		w.ignorers = append(w.ignorers, ig)
+	}
+	return w.addDir(path)
+}
+
+// isIgnored reports whether path is excluded by the ignore files of one of
+// the watched directories.
+func (w *watcher) isIgnored(path string, isDir bool) bool {
+	for _, ig := range w.ignorers {
+		if ig.ignored(path, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// isExcluded reports whether path matches --exclude relative to one of the
+// watched roots.
+func (w *watcher) isExcluded(path string, isDir bool) bool {
+	for _, root := range w.roots {
+		if isExcludedPath(root, path, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// isIncluded reports whether the file at path passes --ext and --include
+// relative to one of the watched roots. Files passed as roots always do.
+func (w *watcher) isIncluded(path string) bool {
+	if w.files[path] {
+		return true
+	}
+	for _, root := range w.roots {
+		if isIncluded(root, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// addDir watches root and every directory below it. fsnotify doesn't watch
+// recursively, so directories created later are added as they show up.
+func (w *watcher) addDir(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && isHidden(path) {
+			return filepath.SkipDir
+		}
+		if isExcludedDir(root, path) || w.isIgnored(path, true) || w.isExcluded(path, true) {
+			return filepath.SkipDir
+		}
+		if err := w.fs.Add(path); err != nil {
+			return err
+		}
+		w.dirs[filepath.Clean(path)] = true
+		return nil
+	})
+}
+
+// watched reports whether path is one of the files asked for or lies in a
+// watched directory.
+func (w *watcher) watched(path string) bool {
+	return w.files[path] || w.dirs[filepath.Dir(path)]
+}
+
+func (w *watcher) observe(event fsnotify.Event) {
+	path := filepath.Clean(event.Name)
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return
+	}
+	if !w.watched(path) || isOwnTempFile(path) {
+		return
+	}
+	info, statErr := os.Lstat(path)
+	isDir := statErr == nil && info.IsDir()
+	if (!w.files[path] && isHidden(path)) || w.isIgnored(path, isDir) || w.isExcluded(path, isDir) || (!isDir && !w.isIncluded(path)) {
+		return
+	}
+	if event.Has(fsnotify.Create) && w.dirs[filepath.Dir(path)] {
+		if statErr == nil && info.IsDir() {
+			if isExcludedDir(filepath.Dir(path), path) {
+				return
+			}
+			if err := w.addDir(path); err != nil {
+				log.Warn("couldn't watch directory", "path", path, "error", err)
+			}
+			// files may have been created before the watch was in place
+			handleDir(path, func(path string) error {
+				w.pending[path] = time.Now()
+				return nil
+			})
+			return
+		}
+	}
+	w.pending[path] = time.Now()
+}
+
+// flush processes the pending paths that have been quiet for the debounce
+// interval.
+func (w *watcher) flush(now time.Time) {
+	for path, changed := range w.pending {
+		if now.Sub(changed) < *watchDebounce {
+			continue
+		}
+		delete(w.pending, path)
+		info, err := os.Lstat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			// removed again, renamed away, a symlink or a directory
+			continue
+		}
+		if mtime, ok := w.written[path]; ok && info.ModTime().Equal(mtime) {
+			log.Debug("ignoring own write", "path", path)
+			continue
+		}
+		res := handleFile(path)
+		if res.Err != nil {
+			// keep watching, the file may be fixed by the next save
+			log.Error("error", "error", res.Err)
+			continue
+		}
+		if res.Changed && !*dryRun {
+			if info, err := os.Stat(path); err == nil {
+				w.written[path] = info.ModTime()
+			}
+		}
+	}
+}
+
+// isOwnTempFile reports whether path looks like the temporary copy
+// safeFileRewrite writes next to a file before renaming it into place.
+func isOwnTempFile(path string) bool {
+	original, ok := strings.CutSuffix(path, ".tmp")
+	if !ok {
+		return false
+	}
+	_, err := os.Stat(original)
+	return !errors.Is(err, fs.ErrNotExist)
+}
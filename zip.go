@@ -0,0 +1,118 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var zipOut = flag.String("out", "", "normalize the text entries of the zip archive given as the only argument and write the result to this `file`. Binary entries and metadata are copied as they are")
+
+// runZip writes a copy of the archive in paths to --out with the line endings
+// of its text entries normalized. Entries that aren't text, use an unsupported
+// encoding or are already normalized are copied without recompressing them.
+func runZip(paths []string) (err error) {
+	if len(paths) != 1 {
+		return errors.New("--out requires exactly one zip archive")
+	}
+	if *staged || *transaction || *watch || *manifest != "" {
+		return errors.New("--out can't be combined with --staged, --transaction, --watch or --manifest")
+	}
+	input := paths[0]
+	if abs, err := filepath.Abs(input); err == nil {
+		if out, err := filepath.Abs(*zipOut); err == nil && abs == out {
+			return errors.New("--out must not be the input archive")
+		}
+	}
+	zr, err := zip.OpenReader(input)
+	if err != nil {
+		return fmt.Errorf("%s: %w", input, err)
+	}
+	defer zr.Close()
+	var output io.Writer = io.Discard
+	if !*dryRun {
+		file, createErr := os.Create(*zipOut)
+		if createErr != nil {
+			return createErr
+		}
+		defer func() {
+			file.Close()
+			if err != nil {
+				os.Remove(*zipOut)
+			}
+		}()
+		output = file
+	}
+	zw := zip.NewWriter(output)
+	for _, f := range zr.File {
+		if err = copyZipEntry(zw, f); err != nil {
+			return fmt.Errorf("%s: %s: %w", input, f.Name, err)
+		}
+	}
+	if err = zw.SetComment(zr.Comment); err != nil {
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		return err
+	}
+	if file, ok := output.(*os.File); ok {
+		return file.Close()
+	}
+	return nil
+}
+
+// copyZipEntry adds f to zw, normalized if it's a text entry.
+func copyZipEntry(zw *zip.Writer, f *zip.File) error {
+	if f.FileInfo().IsDir() {
+		return zw.Copy(f)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	content, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return err
+	}
+	d, err := detectReader(bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	if !d.isText || !supportedEncodings.Contains(strings.ToUpper(d.encoding)) {
+		log.Debug("copying entry as is", "entry", f.Name, "reason", d.reason, "encoding", d.encoding)
+		return zw.Copy(f)
+	}
+	rw := newLineRewriter()
+	rw.setEncoding(d.encoding)
+	rw.setPath(f.Name)
+	if *preserveStyle {
+		if rw.eol, err = dominantEOL(decoded(d.encoding, content), rw.eol); err != nil {
+			return err
+		}
+	}
+	var normalized bytes.Buffer
+	if err := rw.encodedRewrite(d.encoding)(bytes.NewReader(content), &normalized); err != nil {
+		return err
+	}
+	changed := !bytes.Equal(content, normalized.Bytes())
+	log.Info("line endings", "entry", f.Name, "signature", rw.stats.signature(changed))
+	if !changed {
+		return zw.Copy(f)
+	}
+	// keep the name, timestamps, permissions, comment and extra fields. The
+	// writer fills in the sizes and checksum for the new content.
+	header := f.FileHeader
+	w, err := zw.CreateHeader(&header)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(normalized.Bytes())
+	return err
+}